@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestInfo is what the access log needs to know about a request beyond the request itself.
+// It is stored in the context as a pointer, so that the user set by authenticate, which works on
+// a copy of the request, is still visible to logRequests afterwards.
+type requestInfo struct {
+	id     string
+	userID int64
+}
+
+// maxRequestIDLength bounds the request IDs accepted from clients, which end up in every log
+// entry of the request.
+const maxRequestIDLength = 128
+
+// logRequests gives every request an ID and writes one access log entry for it once it has been
+// served. The ID is taken from the X-Request-ID header if the client or a proxy in front of the
+// API sent a sensible one, and is echoed in the response either way.
+func (app *application) logRequests(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var err error
+			id, err = randomString(16)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		info := &requestInfo{id: id}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, info))
+		w.Header().Set("X-Request-ID", id)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		if !app.config.accessLog {
+			return
+		}
+
+		fields := []interface{}{
+			"request_id", id,
+			"method", r.Method,
+			"route", routeTemplate(router, r),
+			"status", sw.status,
+			"bytes", sw.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"remote_ip", app.clientIP(r),
+		}
+		if info.userID != 0 {
+			fields = append(fields, "user_id", info.userID)
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			fields = append(fields, "trace_id", sc.TraceID().String())
+		}
+
+		app.logger.Info("request", fields...)
+	})
+}
+
+// validRequestID reports whether a request ID sent by a client can be used as it is. Only short
+// IDs made of letters, digits and a few separators are accepted, so that they can't be used to
+// forge log entries.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseTrustedProxies parses a space separated list of IP addresses and CIDR ranges.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+
+	for _, field := range strings.Fields(s) {
+		if !strings.Contains(field, "/") {
+			if ip := net.ParseIP(field); ip != nil && ip.To4() != nil {
+				field += "/32"
+			} else {
+				field += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// trustedProxy reports whether ip belongs to one of the trusted proxies.
+func (app *application) trustedProxy(ip net.IP) bool {
+	for _, network := range app.config.proxies.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/my-apishka/validator"
+
+	"gorm.io/gorm"
+)
+
+// passwordResetTTL is how long a forced password reset token stays valid.
+const passwordResetTTL = 24 * time.Hour
+
+// listUsersHandler searches users by email, username and activation state, one page at a time.
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email     string
+		Username  string
+		Activated *bool
+		model.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Email = app.readStrings(qs, "email", "")
+	input.Username = app.readStrings(qs, "username", "")
+
+	if activated := qs.Get("activated"); activated != "" {
+		b, err := strconv.ParseBool(activated)
+		if err != nil {
+			v.AddError("activated", "must be a boolean value")
+		} else {
+			input.Activated = &b
+		}
+	}
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readStrings(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "email", "username", "createdat", "-id", "-email", "-username", "-createdat"}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.Search(r.Context(), input.Email, input.Username, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAdminTarget reads the user named by the "id" URL parameter. It sends the error response
+// itself and returns nil if the user doesn't exist, or if the client sent an X-Expected-Version
+// header which doesn't match the user's current version.
+func (app *application) readAdminTarget(w http.ResponseWriter, r *http.Request) *model.User {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil
+	}
+
+	user, err := app.models.Users.Get(r.Context(), int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	if !app.checkExpectedVersion(w, r, user) {
+		return nil
+	}
+
+	return user
+}
+
+// checkExpectedVersion sends an edit conflict response and returns false if the client sent an
+// X-Expected-Version header which doesn't match the user's current version.
+func (app *application) checkExpectedVersion(w http.ResponseWriter, r *http.Request, user *model.User) bool {
+	if expected := r.Header.Get("X-Expected-Version"); expected != "" {
+		if strconv.Itoa(user.Version) != expected {
+			app.editConflictResponse(w, r)
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeUserWithVersion sends a user together with the version to pass in X-Expected-Version.
+func (app *application) writeUserWithVersion(w http.ResponseWriter, r *http.Request, user *model.User, extra envelope) {
+	env := envelope{"user": user, "version": user.Version}
+	for k, v := range extra {
+		env[k] = v
+	}
+
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserHandler returns a user with their roles and permissions.
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readAdminTarget(w, r)
+	if user == nil {
+		return
+	}
+
+	roles, err := app.models.Permissions.GetRolesForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserWithVersion(w, r, user, envelope{"roles": roles, "permissions": permissions})
+}
+
+// updateUser saves a changed user, sending the error response if that fails.
+func (app *application) updateUser(w http.ResponseWriter, r *http.Request, user *model.User) bool {
+	err := app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateEmail):
+			app.failedValidationResponse(w, r, map[string]string{"email": "a user with this email address already exists"})
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
+
+// revokeUserSessions logs a user out everywhere. Stateless JWT access tokens can't be deleted
+// and can't be refreshed any more; callers which block the user also deny them in the denylist.
+func (app *application) revokeUserSessions(ctx context.Context, userID int64) error {
+	for _, scope := range []string{model.ScopeAuthentication, model.ScopeRefresh, model.ScopeMFAPending} {
+		if err := app.models.Tokens.DeleteAllForUser(ctx, scope, userID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// suspendUserHandler suspends a user, which blocks all of their credentials, and logs them out.
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readAdminTarget(w, r)
+	if user == nil {
+		return
+	}
+
+	if user.ID == app.contextGetUser(r).ID {
+		app.badRequestResponse(w, r, errors.New("you can't suspend your own account"))
+		return
+	}
+
+	if !user.IsSuspended() {
+		now := time.Now()
+		user.SuspendedAt = &now
+
+		if !app.updateUser(w, r, user) {
+			return
+		}
+	}
+
+	// Refuse the user's JWTs on this instance right away. Postgres announces the suspension to
+	// the other instances, see authChannel.
+	app.denylist.setAccountStatus(user.ID, user.AccountStatus())
+
+	err := app.revokeUserSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logUserAction(r, "user suspended", user.ID)
+
+	app.writeUserWithVersion(w, r, user, nil)
+}
+
+// unsuspendUserHandler lifts a suspension.
+func (app *application) unsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readAdminTarget(w, r)
+	if user == nil {
+		return
+	}
+
+	if user.IsSuspended() {
+		user.SuspendedAt = nil
+
+		if !app.updateUser(w, r, user) {
+			return
+		}
+	}
+
+	app.denylist.setAccountStatus(user.ID, user.AccountStatus())
+	app.logUserAction(r, "user unsuspended", user.ID)
+
+	app.writeUserWithVersion(w, r, user, nil)
+}
+
+// forcePasswordResetHandler stops a user from logging in with their current password and logs
+// them out. Their credentials may be compromised, so their API keys are revoked and their JWTs
+// refused until the reset. The response carries a password reset token to hand to the user, who
+// sets a new password with it at PUT /api/v1/users/password.
+func (app *application) forcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readAdminTarget(w, r)
+	if user == nil {
+		return
+	}
+
+	user.PasswordResetRequired = true
+
+	if !app.updateUser(w, r, user) {
+		return
+	}
+
+	// As with a suspension, Postgres announces the change to the other instances.
+	app.denylist.setAccountStatus(user.ID, user.AccountStatus())
+
+	err := app.revokeUserSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.DeleteAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only the newest reset token is valid.
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), model.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, passwordResetTTL, model.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logUserAction(r, "password reset forced", user.ID)
+
+	app.writeUserWithVersion(w, r, user, envelope{"password_reset_token": token})
+}
+
+// deleteUserHandler deletes a user and, through the foreign keys, everything they own.
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readAdminTarget(w, r)
+	if user == nil {
+		return
+	}
+
+	if user.ID == app.contextGetUser(r).ID {
+		app.badRequestResponse(w, r, errors.New("you can't delete your own account here"))
+		return
+	}
+
+	err := app.models.Users.Delete(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.permissionCache.invalidate(user.ID)
+	app.denylist.setAccountStatus(user.ID, model.AccountDeleted)
+	app.logUserAction(r, "user deleted", user.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resetPasswordHandler sets a new password using a password reset token.
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	model.ValidatePasswordPlaintext(v, input.Password)
+	model.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), model.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.PasswordResetRequired = false
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The new password lifts the block on the user's JWTs.
+	app.denylist.setAccountStatus(user.ID, user.AccountStatus())
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), model.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logUserAction records an admin's action on a user account.
+func (app *application) logUserAction(r *http.Request, message string, userID int64) {
+	app.logger.PrintInfo(message, traceProperties(r.Context(), map[string]string{
+		"user_id":  strconv.FormatInt(userID, 10),
+		"admin_id": strconv.FormatInt(app.contextGetUser(r).ID, 10),
+	}))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSuspensionDeniesJWTs(t *testing.T) {
+	app, mock, _ := newMockApplication(t)
+	withJWT(t, app)
+	token := signJWT(t, app, "jti", 2)
+	admin := &model.User{ID: 1, Activated: true}
+
+	user := &model.User{ID: 2, CreatedAt: time.Now(), Username: "draco", Email: "draco@example.com", Activated: true, Version: 1}
+	mock.ExpectQuery("FROM users").WillReturnRows(sqlmock.NewRows(userColumns).AddRow(userValues(user)...))
+	mock.ExpectQuery("UPDATE users").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(2)))
+	for i := 0; i < 3; i++ {
+		mock.ExpectExec("DELETE FROM tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	if rr := serveAs(app, app.suspendUserHandler, http.MethodPut, 2, "", admin); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d suspending; want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	// Without waiting for the notification.
+	if rr := serveJWT(app, token); rr.Code != http.StatusForbidden {
+		t.Errorf("got status %d after the suspension; want %d", rr.Code, http.StatusForbidden)
+	}
+
+	now := time.Now()
+	user.SuspendedAt, user.Version = &now, 2
+	mock.ExpectQuery("FROM users").WillReturnRows(sqlmock.NewRows(userColumns).AddRow(userValues(user)...))
+	mock.ExpectQuery("UPDATE users").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(3)))
+
+	if rr := serveAs(app, app.unsuspendUserHandler, http.MethodDelete, 2, "", admin); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d unsuspending; want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	if rr := serveJWT(app, token); rr.Code != http.StatusOK {
+		t.Errorf("got status %d after lifting the suspension; want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestForcedPasswordResetDeniesCredentials(t *testing.T) {
+	app, mock, _ := newMockApplication(t)
+	withJWT(t, app)
+	token := signJWT(t, app, "jti", 2)
+	admin := &model.User{ID: 1, Activated: true}
+
+	user := &model.User{ID: 2, CreatedAt: time.Now(), Username: "draco", Email: "draco@example.com", Activated: true, Version: 1}
+	mock.ExpectQuery("FROM users").WillReturnRows(sqlmock.NewRows(userColumns).AddRow(userValues(user)...))
+	mock.ExpectQuery("UPDATE users").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(2)))
+	for i := 0; i < 3; i++ {
+		mock.ExpectExec("DELETE FROM tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec("DELETE FROM api_keys").WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM tokens").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if rr := serveAs(app, app.forcePasswordResetHandler, http.MethodPost, 2, "", admin); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d forcing the reset; want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	if rr := serveJWT(app, token); rr.Code != http.StatusForbidden {
+		t.Errorf("got status %d for a JWT after the forced reset; want %d", rr.Code, http.StatusForbidden)
+	}
+
+	// A key looked up while the reset was being saved is refused as well.
+	user.PasswordResetRequired, user.Version = true, 2
+	key := expectAPIKey(mock, user, nil)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "ApiKey "+key)
+	rr := httptest.NewRecorder()
+	app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("got status %d for an API key after the forced reset; want %d", rr.Code, http.StatusForbidden)
+	}
+
+	// Setting the new password lets the user in again.
+	mock.ExpectQuery("FROM users").WillReturnRows(sqlmock.NewRows(userColumns).AddRow(userValues(user)...))
+	mock.ExpectQuery("UPDATE users").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(3)))
+	mock.ExpectExec("DELETE FROM tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r = httptest.NewRequest(http.MethodPut, "/api/v1/users/password",
+		strings.NewReader(`{"password": "pa55word1234", "token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"}`))
+	rr = httptest.NewRecorder()
+	app.resetPasswordHandler(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d resetting the password; want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	if rr := serveJWT(app, signJWT(t, app, "jti2", 2)); rr.Code != http.StatusOK {
+		t.Errorf("got status %d for a new JWT after the reset; want %d", rr.Code, http.StatusOK)
+	}
+}
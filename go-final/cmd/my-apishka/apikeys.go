@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/my-apishka/validator"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// authenticateAPIKey looks up an API key and, if it is valid, returns the request with its owner
+// and the key in the context.
+func (app *application) authenticateAPIKey(r *http.Request, plaintext string) (*http.Request, error) {
+	key, user, err := app.models.APIKeys.GetForKey(r.Context(), plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys used by busy scripts are only touched once per interval, like sessions.
+	if app.sessions.due(plaintext) {
+		if err := app.models.APIKeys.Touch(r.Context(), key.ID); err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAuth(r, &authInfo{token: plaintext, apiKey: key})
+
+	return r, nil
+}
+
+// createAPIKeyHandler creates a named API key for the authenticated user. The key itself is
+// only ever returned in this response.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	key := &model.APIKey{
+		UserID:      user.ID,
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		Expiry:      input.Expiry,
+	}
+
+	v := validator.New()
+	model.ValidateAPIKey(v, key)
+
+	if key.Permissions != nil {
+		known, err := app.models.Permissions.GetAll(r.Context())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, code := range key.Permissions {
+			if !validator.In(code, known...) {
+				v.AddError("permissions", "unknown permission code "+strconv.Quote(code))
+				break
+			}
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	plaintext, err := app.models.APIKeys.Insert(r.Context(), key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var res struct {
+		Key    string        `json:"key"`
+		APIKey *model.APIKey `json:"api_key"`
+	}
+
+	res.Key = plaintext
+	res.APIKey = key
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": res}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listAPIKeysHandler returns the API keys of the authenticated user, without the keys themselves.
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	keys, err := app.models.APIKeys.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAPIKeyHandler revokes an API key of the authenticated user.
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestResponse(w, r, errors.New("invalid id parameter"))
+		return
+	}
+
+	err = app.models.APIKeys.DeleteForUser(r.Context(), id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectAPIKey sets up the lookup of a valid API key of user, scoped to permissions unless they
+// are nil, and returns the key.
+func expectAPIKey(mock sqlmock.Sqlmock, user *model.User, permissions []string) string {
+	plaintext := "hpk_abcdefgh_" + strings.Repeat("s", 32)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	var scope driver.Value
+	if permissions != nil {
+		scope = "{" + strings.Join(permissions, ",") + "}"
+	}
+
+	columns := append([]string{"id", "name", "prefix", "hash", "permissions", "expiry", "created_at", "last_used_at"}, userColumns...)
+	values := append([]driver.Value{int64(7), "ci", "abcdefgh", hash[:], scope, nil, time.Now(), nil}, userValues(user)...)
+
+	mock.ExpectQuery("FROM api_keys").WillReturnRows(sqlmock.NewRows(columns).AddRow(values...))
+	mock.ExpectExec("UPDATE api_keys").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	return plaintext
+}
+
+func TestAPIKeysCannotManageCredentials(t *testing.T) {
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/api/v1/users/me/api-keys"},
+		{http.MethodGet, "/api/v1/users/me/api-keys"},
+		{http.MethodDelete, "/api/v1/users/me/api-keys/1"},
+		{http.MethodPost, "/api/v1/users/me/2fa"},
+		{http.MethodPost, "/api/v1/users/me/2fa/confirm"},
+		{http.MethodDelete, "/api/v1/users/me/2fa"},
+		{http.MethodPut, "/api/v1/users/me/password"},
+		{http.MethodPost, "/api/v1/users/me/email"},
+		{http.MethodDelete, "/api/v1/users/me"},
+	}
+
+	for _, scope := range [][]string{nil, {"characters:read"}} {
+		for _, route := range routes {
+			t.Run(route.method+" "+route.path, func(t *testing.T) {
+				app, mock, _ := newMockApplication(t)
+				key := expectAPIKey(mock, &model.User{ID: 1, Activated: true}, scope)
+
+				body := strings.NewReader(`{"name": "broader", "password": "pa55word1234"}`)
+				r := httptest.NewRequest(route.method, route.path, body)
+				r.Header.Set("Authorization", "ApiKey "+key)
+				rr := httptest.NewRecorder()
+
+				app.routes().ServeHTTP(rr, r)
+
+				if rr.Code != http.StatusForbidden {
+					t.Errorf("got status %d; want %d", rr.Code, http.StatusForbidden)
+				}
+				if !strings.Contains(rr.Body.String(), "API key") {
+					t.Errorf("got body %q; want the API key refusal", rr.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestCreateAPIKeyUnknownPermission(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions string
+		wantStatus  int
+	}{
+		{"unscoped", `null`, http.StatusCreated},
+		{"known codes", `["characters:read", "comments:*"]`, http.StatusCreated},
+		{"unknown code", `["characters:read", "everything"]`, http.StatusUnprocessableEntity},
+		{"wildcard not in the table", `["*"]`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := newMockApplication(t)
+
+			if tt.permissions != "null" {
+				mock.ExpectQuery("SELECT code FROM permissions").WillReturnRows(
+					sqlmock.NewRows([]string{"code"}).AddRow("characters:read").AddRow("comments:*"))
+			}
+			if tt.wantStatus == http.StatusCreated {
+				mock.ExpectQuery("INSERT INTO api_keys").WillReturnRows(
+					sqlmock.NewRows([]string{"id", "created_at"}).AddRow(int64(1), time.Now()))
+			}
+
+			body := strings.NewReader(`{"name": "ci", "permissions": ` + tt.permissions + `}`)
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/users/me/api-keys", body)
+			r = withUser(app, r, &model.User{ID: 1, Activated: true})
+			rr := httptest.NewRecorder()
+
+			app.createAPIKeyHandler(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-final/pkg/jsonlog"
+	"go-final/pkg/jwt"
+	"go-final/pkg/my-apishka/model"
+
+	"github.com/lib/pq"
+)
+
+// loadKeySet loads the JWT keys listed in the -jwt-keys flag. The flag is a comma separated list
+// of kid=path pairs; keys other than the signing key are only used to verify tokens, which is
+// how a retired key stays valid until the tokens it signed expire.
+func loadKeySet(keys, signingID string) (*jwt.KeySet, error) {
+	var loaded []*jwt.Key
+
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kid, path, ok := strings.Cut(pair, "=")
+		if !ok || kid == "" || path == "" {
+			return nil, fmt.Errorf("invalid JWT key %q, expected kid=path", pair)
+		}
+
+		key, err := jwt.LoadKey(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, key)
+	}
+
+	if len(loaded) == 0 {
+		return nil, errors.New("JWT mode requires at least one key in -jwt-keys")
+	}
+
+	// Sign with the first key unless told otherwise.
+	if signingID == "" {
+		signingID = loaded[0].ID
+	}
+
+	return jwt.NewKeySet(signingID, loaded...)
+}
+
+// authChannel is the Postgres notification channel on which JWT revocations and account status
+// changes are announced, see migration 20240310121400.
+const authChannel = "auth_changed"
+
+// denylist holds what a stateless JWT can't tell: whether it was revoked before it expired, and
+// whether its user has since been suspended, scheduled for deletion, forced to reset their
+// password or deleted. The list lives
+// in memory, which keeps the check free of database round-trips; access tokens are short-lived,
+// so it stays small. Revocations are stored in the database, and Postgres announces them and
+// status changes to every instance, so a logout or a suspension takes effect on all of them.
+type denylist struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	// accounts holds the status of the users who may not use their JWTs, by user ID.
+	accounts map[int64]string
+
+	// listener is set by listen.
+	listener *notifyListener
+}
+
+func newDenylist() *denylist {
+	return &denylist{
+		entries:  make(map[string]time.Time),
+		accounts: make(map[int64]string),
+	}
+}
+
+// add revokes the token with the given ID until its expiry time.
+func (d *denylist) add(id string, expiry time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Forget tokens which have expired anyway.
+	now := time.Now()
+	for k, until := range d.entries {
+		if now.After(until) {
+			delete(d.entries, k)
+		}
+	}
+
+	d.entries[id] = expiry
+}
+
+// contains reports whether the token with the given ID has been revoked.
+func (d *denylist) contains(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, ok := d.entries[id]
+	return ok
+}
+
+// setAccountStatus records the status of a user, one of the model.Account constants.
+func (d *denylist) setAccountStatus(userID int64, status string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if status == model.AccountActive {
+		delete(d.accounts, userID)
+		return
+	}
+
+	d.accounts[userID] = status
+}
+
+// accountStatus returns the status of a user, model.AccountActive unless they are blocked.
+func (d *denylist) accountStatus(userID int64) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if status, ok := d.accounts[userID]; ok {
+		return status
+	}
+	return model.AccountActive
+}
+
+// replace swaps the whole list for a fresh copy loaded from the database.
+func (d *denylist) replace(entries map[string]time.Time, accounts map[int64]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.entries = entries
+	d.accounts = accounts
+}
+
+// listen applies the revocations and status changes announced by Postgres in a background
+// goroutine, until stop is called. After the connection drops reload is called, since
+// notifications sent in the meantime are lost.
+func (d *denylist) listen(dsn string, logger *jsonlog.Logger, reload func()) error {
+	listener, err := listenNotify(dsn, logger, authChannel, func(n *pq.Notification) {
+		if n == nil {
+			reload()
+			return
+		}
+
+		if err := d.apply(n.Extra); err != nil {
+			logger.PrintError(err, map[string]string{"listener": authChannel})
+			reload()
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	d.listener = listener
+	return nil
+}
+
+// apply records one notification payload, see authChannel.
+func (d *denylist) apply(payload string) error {
+	kind, rest, _ := strings.Cut(payload, ":")
+
+	switch kind {
+	case "token":
+		expiry, id, ok := strings.Cut(rest, ":")
+		if !ok {
+			break
+		}
+
+		seconds, err := strconv.ParseInt(expiry, 10, 64)
+		if err != nil {
+			break
+		}
+
+		d.add(id, time.Unix(seconds, 0))
+		return nil
+
+	case "user":
+		id, status, ok := strings.Cut(rest, ":")
+		if !ok {
+			break
+		}
+
+		userID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			break
+		}
+
+		d.setAccountStatus(userID, status)
+		return nil
+	}
+
+	return fmt.Errorf("invalid %s payload %q", authChannel, payload)
+}
+
+// stop ends the goroutine started by listen and closes its connection. It does nothing if the
+// list isn't listening.
+func (d *denylist) stop() {
+	d.listener.stop()
+}
+
+// loadDenylist replaces the denylist with the revocations and blocked users in the database.
+func (app *application) loadDenylist(ctx context.Context) error {
+	entries, err := app.models.Tokens.GetRevokedJWTs(ctx)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := app.models.Users.GetBlockedStatuses(ctx)
+	if err != nil {
+		return err
+	}
+
+	app.denylist.replace(entries, accounts)
+	return nil
+}
+
+// newAccessJWT signs a stateless access token for the user. The token carries the user's
+// activation status and permission codes as they are at the time of issue.
+func (app *application) newAccessJWT(ctx context.Context, userID int64, family []byte) (*model.Token, error) {
+	user, err := app.models.Users.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.tokens.accessTTL)
+
+	plaintext, err := app.jwt.Sign(jwt.Claims{
+		Subject:     strconv.FormatInt(user.ID, 10),
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   expiry.Unix(),
+		ID:          id,
+		Session:     hex.EncodeToString(family),
+		Activated:   user.Activated,
+		Permissions: permissions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Token{
+		Plaintext: plaintext,
+		UserID:    user.ID,
+		Expiry:    expiry,
+		Scope:     model.ScopeAuthentication,
+	}, nil
+}
+
+// authenticateJWT verifies a JWT access token locally and, if it is valid, returns the request
+// with the user and credentials it describes in the context.
+func (app *application) authenticateJWT(r *http.Request, token string) (*http.Request, error) {
+	claims, err := app.jwt.Verify(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if app.denylist.contains(claims.ID) {
+		return nil, errors.New("token has been revoked")
+	}
+
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	// The user is built from the claims alone. Handlers that need more than the ID and the
+	// activation status have to load the user themselves.
+	user := &model.User{
+		ID:        id,
+		Activated: claims.Activated,
+	}
+
+	// The denylist only knows that the account is blocked, not since when, which is all
+	// checkAccountStatus looks at.
+	now := time.Now()
+	switch app.denylist.accountStatus(id) {
+	case model.AccountSuspended:
+		user.SuspendedAt = &now
+	case model.AccountDeleting:
+		user.DeletionScheduledAt = &now
+	case model.AccountPasswordReset:
+		user.PasswordResetRequired = true
+	case model.AccountDeleted:
+		return nil, errors.New("user has been deleted")
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAuth(r, &authInfo{token: token, claims: claims})
+
+	return r, nil
+}
+
+// logoutHandler ends the session the request was authenticated with. Other sessions of the same
+// user stay logged in.
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	auth := app.contextGetAuth(r)
+
+	if auth.claims != nil {
+		expiry := time.Unix(auth.claims.ExpiresAt, 0)
+
+		// The notification for the new row updates the denylists of the other instances. This
+		// one doesn't wait for it.
+		err := app.models.Tokens.RevokeJWT(r.Context(), auth.claims.ID, expiry)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.denylist.add(auth.claims.ID, expiry)
+
+		family, err := hex.DecodeString(auth.claims.Session)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(family) > 0 {
+			err = app.models.Tokens.DeleteFamily(r.Context(), family)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	} else {
+		err := app.models.Tokens.DeleteFamilyForToken(r.Context(), auth.token)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// serveJWT passes a request carrying token through authenticate to a handler which answers 200.
+func serveJWT(app *application, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rr, r)
+
+	return rr
+}
+
+func TestJWTAccountStatus(t *testing.T) {
+	tests := []struct {
+		status     string
+		wantStatus int
+	}{
+		{model.AccountActive, http.StatusOK},
+		{model.AccountSuspended, http.StatusForbidden},
+		{model.AccountDeleting, http.StatusForbidden},
+		{model.AccountPasswordReset, http.StatusForbidden},
+		{model.AccountDeleted, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			app, _, _ := newMockApplication(t)
+			withJWT(t, app)
+			token := signJWT(t, app, "jti", 1)
+
+			// As announced by the users_status_changed trigger.
+			if err := app.denylist.apply("user:1:" + tt.status); err != nil {
+				t.Fatal(err)
+			}
+
+			rr := serveJWT(app, token)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestJWTUnsuspended(t *testing.T) {
+	app, _, _ := newMockApplication(t)
+	withJWT(t, app)
+	token := signJWT(t, app, "jti", 1)
+
+	app.denylist.replace(map[string]time.Time{}, map[int64]string{1: model.AccountSuspended})
+	if err := app.denylist.apply("user:1:active"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := serveJWT(app, token); rr.Code != http.StatusOK {
+		t.Errorf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+}
+
+func TestJWTRevokedElsewhere(t *testing.T) {
+	app, _, _ := newMockApplication(t)
+	withJWT(t, app)
+	token := signJWT(t, app, "jti", 1)
+	other := signJWT(t, app, "other", 1)
+
+	// As announced by the revoked_jwts_inserted trigger after a logout on another instance.
+	expiry := time.Now().Add(time.Hour).Unix()
+	if err := app.denylist.apply("token:" + strconv.FormatInt(expiry, 10) + ":jti"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := serveJWT(app, token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d for the revoked token; want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := serveJWT(app, other); rr.Code != http.StatusOK {
+		t.Errorf("got status %d for another token; want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestDenylistInvalidPayload(t *testing.T) {
+	for _, payload := range []string{"", "token", "token:soon:jti", "token:1700000000", "user:1", "user:me:suspended", "session:1"} {
+		if err := newDenylist().apply(payload); err == nil {
+			t.Errorf("got no error for %q", payload)
+		}
+	}
+}
+
+func TestLogoutRevokesJWT(t *testing.T) {
+	app, mock, _ := newMockApplication(t)
+	withJWT(t, app)
+	token := signJWT(t, app, "jti", 1)
+
+	mock.ExpectExec("INSERT INTO revoked_jwts").WithArgs("jti", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM tokens").WillReturnResult(sqlmock.NewResult(0, 2))
+
+	r := httptest.NewRequest(http.MethodDelete, "/api/v1/tokens/authentication", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	if rr := serveJWT(app, token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d after logout; want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
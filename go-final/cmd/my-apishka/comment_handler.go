@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/policy"
 	// "go-final/pkg/my-apishka/validator"
 
 	"github.com/gorilla/mux"
@@ -17,9 +18,12 @@ type CommentHandler struct {
 	Model *model.CommentModel
 }
 
-// CreateCommentHandler обрабатывает запрос на создание нового комментария.
+// CreateCommentHandler обрабатывает запрос на создание нового комментария. Автором всегда
+// становится текущий пользователь, иначе политика "comment-author" проверяла бы владельца,
+// которого указал сам клиент.
 func (app *application) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
+		// UsernameID принимается для совместимости со старыми клиентами, но не используется.
 		UsernameID  int64  `json:"UsernameID"`
 		Comment     string `json:"Comment"`
 		CharacterID int64  `json:"CharacterID"`
@@ -32,12 +36,12 @@ func (app *application) CreateCommentHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	comment := &model.Comment{
-		UsernameID:  input.UsernameID,
+		UsernameID:  app.contextGetUser(r).ID,
 		Comment:     input.Comment,
 		CharacterID: input.CharacterID,
 	}
 
-	err = app.models.Comments.CreateComment(comment)
+	err = app.models.Comments.CreateComment(r.Context(), comment)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 		return
@@ -65,7 +69,7 @@ func (app *application) GetCommentHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	comment, err := app.models.Comments.GetCommentByID(id)
+	comment, err := app.models.Comments.GetCommentByID(r.Context(), id)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
@@ -85,12 +89,16 @@ func (app *application) UpdateCommentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	comment, err := app.models.Comments.GetCommentByID(id)
-	if err != nil {
+	comment, err := app.models.Comments.GetCommentByID(r.Context(), id)
+	if err != nil || comment == nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
 	}
 
+	if !app.authorize(w, r, actionUpdateComment, commentResource(comment)) {
+		return
+	}
+
 	var input struct {
 		Comment *string `json:"Comment"`
 	}
@@ -105,7 +113,7 @@ func (app *application) UpdateCommentHandler(w http.ResponseWriter, r *http.Requ
 		comment.Comment = *input.Comment
 	}
 
-	err = app.models.Comments.UpdateComment(comment)
+	err = app.models.Comments.UpdateComment(r.Context(), comment)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 	}
@@ -124,7 +132,17 @@ func (app *application) DeleteCommentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.Comments.DeleteCommentByID(id)
+	comment, err := app.models.Comments.GetCommentByID(r.Context(), id)
+	if err != nil || comment == nil {
+		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
+		return
+	}
+
+	if !app.authorize(w, r, actionDeleteComment, commentResource(comment)) {
+		return
+	}
+
+	err = app.models.Comments.DeleteCommentByID(r.Context(), id)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 	}
@@ -132,6 +150,16 @@ func (app *application) DeleteCommentHandler(w http.ResponseWriter, r *http.Requ
 	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
 
+// commentResource describes a comment to the policy engine.
+func commentResource(comment *model.Comment) policy.Resource {
+	return policy.Resource{
+		Kind:      "comment",
+		ID:        int64(comment.Id),
+		OwnerID:   comment.UsernameID,
+		CreatedAt: comment.CreatedAt,
+	}
+}
+
 // фильтр,сорт,пагинация
 func (app *application) getCommentsByUserIDHandler(w http.ResponseWriter, r *http.Request) {
 	userIDStr := r.URL.Query().Get("userID")
@@ -147,7 +175,7 @@ func (app *application) getCommentsByUserIDHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
-	comments, err := app.models.Comments.GetCommentsByUserID(userID)
+	comments, err := app.models.Comments.GetCommentsByUserID(r.Context(), userID)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Failed to fetch comments.")
 		return
@@ -158,7 +186,7 @@ func (app *application) getCommentsByUserIDHandler(w http.ResponseWriter, r *htt
 
 func (app *application) getCommentsByCharacterIDHandler(w http.ResponseWriter, r *http.Request) {
 	// Здесь предполагается, что фильтрация по айди персонажа = айди персонажа
-	comments, err := app.models.Comments.GetCommentsByCharacter()
+	comments, err := app.models.Comments.GetCommentsByCharacter(r.Context())
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Failed to fetch comments.")
 		return
@@ -183,7 +211,7 @@ func (app *application) getCommentsWithPaginationHandler(w http.ResponseWriter,
 		return
 	}
 
-	comments, err := app.models.Comments.GetCommentsPagination(limit, offset)
+	comments, err := app.models.Comments.GetCommentsPagination(r.Context(), limit, offset)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Failed to fetch comments.")
 		return
@@ -201,7 +229,7 @@ func (app *application) getCharacterCommentsHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	comments, err := app.models.Comments.GetCommentsByCharacterID(characterID)
+	comments, err := app.models.Comments.GetCommentsByCharacterID(r.Context(), characterID)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Failed to get character comments")
 		return
@@ -219,7 +247,7 @@ func (app *application) getUserCommentsHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	comments, err := app.models.Comments.GetCommentsByUser(userID)
+	comments, err := app.models.Comments.GetCommentsByUser(r.Context(), userID)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Failed to get comments")
 		return
@@ -3,8 +3,13 @@ package main
 import (
 	"context"
 	"net/http"
+	"strconv"
 
+	"go-final/pkg/jwt"
 	"go-final/pkg/my-apishka/model"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type contextKey string
@@ -13,9 +18,36 @@ type contextKey string
 // context.
 const userContextKey = contextKey("user")
 
+// authContextKey is used as a key for the credentials the request was authenticated with.
+const authContextKey = contextKey("auth")
+
+// requestInfoContextKey is used as a key for the request ID and the other details the access
+// log needs.
+const requestInfoContextKey = contextKey("request-info")
+
+// authInfo describes how a request was authenticated.
+type authInfo struct {
+	// token is the credential taken from the Authorization header.
+	token string
+	// claims is set when the request carried a JWT. Its permissions are used instead of
+	// looking them up in the database.
+	claims *jwt.Claims
+	// apiKey is set when the request was authenticated with an API key. If the key is scoped,
+	// only the permissions listed on it are granted, on top of the owner's own checks.
+	apiKey *model.APIKey
+}
+
 // contextSetUser returns a new copy of the request with the provided User struct added to the
-// context.
+// context. Authenticated users are also recorded on the request's span and for the access log.
 func (app *application) contextSetUser(r *http.Request, user *model.User) *http.Request {
+	if !user.IsAnonymous() {
+		trace.SpanFromContext(r.Context()).SetAttributes(semconv.EnduserID(strconv.FormatInt(user.ID, 10)))
+
+		if info := app.contextGetRequestInfo(r); info != nil {
+			info.userID = user.ID
+		}
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -30,4 +62,25 @@ func (app *application) contextGetUser(r *http.Request) *model.User {
 	}
 
 	return user
-}
\ No newline at end of file
+}
+
+// contextSetAuth returns a new copy of the request with the provided authInfo added to the
+// context.
+func (app *application) contextSetAuth(r *http.Request, auth *authInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), authContextKey, auth)
+	return r.WithContext(ctx)
+}
+
+// contextGetAuth retrieves the authInfo from the request context. Unlike contextGetUser it
+// returns nil for anonymous requests, which carry no credentials.
+func (app *application) contextGetAuth(r *http.Request) *authInfo {
+	auth, _ := r.Context().Value(authContextKey).(*authInfo)
+	return auth
+}
+
+// contextGetRequestInfo retrieves the requestInfo set by logRequests, or nil if the request
+// didn't go through it.
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
@@ -1,17 +1,35 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // logError method is a generic helper for logging an error message in *application, as well
-// as the requested method and request URL.
+// as the requested method and request URL, without credentials (see loggedURL), the request ID,
+// the user who made the request and the trace the request belongs to.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	properties := map[string]string{
 		"request_method": r.Method,
-		"request_url":    r.URL.String(),
-	})
+		"request_url":    loggedURL(r),
+	}
+
+	// The user is taken from the request info rather than the context, since middleware which
+	// runs before authenticate, recoverPanic in particular, holds a request without the user.
+	if info := app.contextGetRequestInfo(r); info != nil {
+		properties["request_id"] = info.id
+
+		if info.userID != 0 {
+			properties["user_id"] = strconv.FormatInt(info.userID, 10)
+		}
+	}
+
+	app.logger.PrintError(err, traceProperties(r.Context(), properties))
 }
 
 // errorResponse method is a generic helper for sending JSON-formatted error messages to the
@@ -36,6 +54,16 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 // 500 Internal Server Error status code and JSON response (containing the generic error message)
 // to the client
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	// A query aborted because the client went away isn't a problem of the server, and there is
+	// nobody left to read the response.
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		app.logger.PrintInfo("request cancelled by the client", traceProperties(r.Context(), map[string]string{
+			"request_method": r.Method,
+			"request_url":    loggedURL(r),
+		}))
+		return
+	}
+
 	app.logError(r, err)
 	
 	message := "the server encountered a problem and could not process your request"
@@ -92,6 +120,15 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// invalidAPIKeyResponse sends a JSON-formatted error with a 401 Unauthorized status code and
+// "WWW-Authenticate: ApiKey" header to the client.
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "ApiKey")
+
+	message := "invalid or expired API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 // authenticationRequiredResponse sends a JSON-formatted error with a 401 Unauthorized status code
 // to the client.
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
@@ -106,7 +143,61 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+// accountSuspendedResponse sends a 403 Forbidden response to a suspended user.
+func (app *application) accountSuspendedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// accountPendingDeletionResponse sends a 403 Forbidden response to a user whose account is
+// scheduled for deletion.
+func (app *application) accountPendingDeletionResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account is scheduled for deletion, log in again to keep it"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// passwordResetRequiredResponse sends a 403 Forbidden response to a user who has to reset their
+// password before logging in again.
+func (app *application) passwordResetRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must reset your password before you can log in"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
-}
\ No newline at end of file
+}
+
+// apiKeyNotAllowedResponse sends a 403 Forbidden response when an API key is used for a route
+// which needs the user's own login.
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this action can't be performed with an API key, please log in"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// tooManyLoginAttemptsResponse sends a JSON-formatted error with a 429 Too Many Requests status
+// code and a "Retry-After" header telling the client how many seconds to wait.
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// activationResendTooSoonResponse sends a 429 Too Many Requests response with a "Retry-After"
+// header when a new activation token was asked for too soon after the last one.
+func (app *application) activationResendTooSoonResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "an activation token was issued recently, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// rateLimitExceededResponse sends a 429 Too Many Requests response with a "Retry-After" header
+// to a client which went over one of its rate limits.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+)
+
+func TestCancelledRequestAbortsQuery(t *testing.T) {
+	db := sql.OpenDB(blockingConnector{})
+	defer db.Close()
+
+	app, logs := newTestApplication(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/users/me/api-keys", nil).WithContext(ctx)
+	r = withUser(app, r, &model.User{ID: 1, Activated: true})
+	rr := httptest.NewRecorder()
+
+	// The client hangs up while the query is running.
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	done := make(chan struct{})
+	go func() {
+		app.listAPIKeysHandler(rr, r)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler still running a second after the request was cancelled")
+	}
+
+	if rr.Body.Len() != 0 {
+		t.Errorf("got body %q; want no response for a client which has gone", rr.Body.String())
+	}
+
+	if !strings.Contains(logs.String(), "request cancelled by the client") {
+		t.Errorf("got logs %q; want the cancellation logged", logs.String())
+	}
+	if strings.Contains(logs.String(), `"level":"ERROR"`) {
+		t.Errorf("got logs %q; want no error logged", logs.String())
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/my-apishka/validator"
+
+	"gorm.io/gorm"
+)
+
+// exportDownloadTTL is how long the download link of a data export stays valid.
+const exportDownloadTTL = 24 * time.Hour
+
+// requestExportHandler starts building an archive of everything stored about the authenticated
+// user. The archive is built in the background; the response carries the link to download it
+// from once GET /api/v1/users/me/export reports it as complete.
+func (app *application) requestExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	export, err := app.models.Exports.Start(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrExportPending):
+			app.errorResponse(w, r, http.StatusConflict, "an export is already being prepared, please wait for it to complete")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Links to earlier exports would now download this one, so they are revoked.
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), model.ScopeExportDownload, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, exportDownloadTTL, model.ScopeExportDownload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The export outlives the request, but stays part of its trace.
+	ctx := context.WithoutCancel(r.Context())
+	app.background(func() {
+		app.buildExport(ctx, export, user)
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{
+		"export":       export,
+		"download_url": "/api/v1/exports/download?token=" + token.Plaintext,
+		"expiry":       token.Expiry,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showExportHandler returns the state of the authenticated user's latest export.
+func (app *application) showExportHandler(w http.ResponseWriter, r *http.Request) {
+	export, err := app.models.Exports.GetForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"export": export}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// downloadExportHandler sends the archive of a completed export. The token in the query string
+// is the only credential, so the link works from a browser.
+func (app *application) downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	tokenPlaintext := r.URL.Query().Get("token")
+
+	v := validator.New()
+
+	if model.ValidateTokenPlaintext(v, tokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), model.ScopeExportDownload, tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired download link")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	archive, export, err := app.models.Exports.GetArchive(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, "the export is not ready, check its status at /api/v1/users/me/export")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	filename := fmt.Sprintf("export-%d-%s.zip", user.ID, export.CreatedAt.Format("20060102"))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+// buildExport collects the user's data into a zip archive and stores it. It runs in the
+// background, so failures are logged and recorded on the export rather than returned. ctx must
+// not be cancelled when the request which has already been answered ends.
+func (app *application) buildExport(ctx context.Context, export *model.Export, user *model.User) {
+	archive, err := app.exportArchive(ctx, user)
+	if err == nil {
+		err = app.models.Exports.Complete(ctx, export.ID, archive)
+	}
+
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"export_id": strconv.FormatInt(export.ID, 10),
+			"user_id":   strconv.FormatInt(user.ID, 10),
+		})
+
+		err = app.models.Exports.Fail(ctx, export.ID, "the export could not be prepared, please request a new one")
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+		return
+	}
+
+	app.logger.PrintInfo("data export complete", map[string]string{
+		"export_id": strconv.FormatInt(export.ID, 10),
+		"user_id":   strconv.FormatInt(user.ID, 10),
+		"bytes":     strconv.Itoa(len(archive)),
+	})
+}
+
+// exportFile is a file of an export archive, which holds data encoded as JSON.
+type exportFile struct {
+	name        string
+	description string
+	data        interface{}
+}
+
+// exportArchive returns a zip archive with one JSON file for each kind of data stored about
+// the user, and a manifest.json describing them.
+func (app *application) exportArchive(ctx context.Context, user *model.User) ([]byte, error) {
+	comments, err := app.models.Comments.GetCommentsByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := app.models.Permissions.GetRolesForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	houses, err := app.models.Permissions.GetHousesForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []exportFile{
+		{"profile.json", "the account, including whether it is suspended or scheduled for deletion", user},
+		{"comments.json", "the comments written by the user", comments},
+		{"sessions.json", "the devices the user is logged in on", sessions},
+		{"api_keys.json", "the user's API keys, without the keys themselves", apiKeys},
+		{"identities.json", "the accounts at identity providers linked for login", identities},
+		{"access.json", "the user's roles, permissions and houses", envelope{"roles": roles, "permissions": permissions, "houses": houses}},
+	}
+
+	// The manifest lists the files, and what isn't in them and why.
+	contents := make(map[string]string, len(files))
+	for _, file := range files {
+		contents[file.name] = file.description
+	}
+
+	manifest := envelope{
+		"user_id":    user.ID,
+		"created_at": time.Now(),
+		"files":      contents,
+		"not_included": map[string]string{
+			"reactions": "the API doesn't store reactions",
+			"audit_log": "admin actions on the account, such as suspensions and forced password resets, are only " +
+				"written to the server logs; their current effect is shown in profile.json",
+		},
+	}
+
+	files = append([]exportFile{{name: "manifest.json", data: manifest}}, files...)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, file := range files {
+		js, err := json.MarshalIndent(file.data, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := f.Write(js); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-final/pkg/my-apishka/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestExportManifest(t *testing.T) {
+	app, mock, _ := newMockApplication(t)
+
+	for _, table := range []string{"comments", "tokens", "api_keys", "user_identities", "roles", "permissions", "users_houses"} {
+		mock.ExpectQuery("FROM " + table).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+
+	archive, err := app.exportArchive(context.Background(), &model.User{ID: 1, Username: "luna"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		names    []string
+		manifest struct {
+			Files       map[string]string `json:"files"`
+			NotIncluded map[string]string `json:"not_included"`
+		}
+	)
+
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if f.Name != "manifest.json" {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		js, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := json.Unmarshal(js, &manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(names) == 0 || names[0] != "manifest.json" {
+		t.Fatalf("got files %v; want manifest.json first", names)
+	}
+
+	for _, name := range names[1:] {
+		if manifest.Files[name] == "" {
+			t.Errorf("%s isn't described in the manifest", name)
+		}
+	}
+	if len(manifest.Files) != len(names)-1 {
+		t.Errorf("got %d files in the manifest; want %d", len(manifest.Files), len(names)-1)
+	}
+
+	for _, omitted := range []string{"reactions", "audit_log"} {
+		if manifest.NotIncluded[omitted] == "" {
+			t.Errorf("the manifest doesn't explain why %s are missing", omitted)
+		}
+	}
+}
+
+func TestLoggedURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/api/v1/characters?page=2", "/api/v1/characters?page=2"},
+		{"/api/v1/exports/download?token=ABCDEFGHIJKLMNOPQRSTUVWXYZ", "/api/v1/exports/download?token=REDACTED"},
+		{"/api/v1/oidc/callback?state=s3cr3t&code=c0de", "/api/v1/oidc/callback?code=REDACTED&state=REDACTED"},
+		{"/api/v1/exports/download?token=", "/api/v1/exports/download?token=REDACTED"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+
+		if got := loggedURL(r); got != tt.want {
+			t.Errorf("%s: got %s; want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadTokenNotLogged(t *testing.T) {
+	app, mock, logs := newMockApplication(t)
+
+	const token = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	mock.ExpectQuery("FROM users").WillReturnError(errors.New("connection reset by peer"))
+
+	rr := httptest.NewRecorder()
+	app.downloadExportHandler(rr, httptest.NewRequest(http.MethodGet, "/api/v1/exports/download?token="+token, nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(logs.String(), token) {
+		t.Errorf("got logs %q; want the token redacted", logs.String())
+	}
+	if !strings.Contains(logs.String(), "token=REDACTED") {
+		t.Errorf("got logs %q; want the redacted URL", logs.String())
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/policy"
 	// "go-final/pkg/my-apishka/validator"
 	"net/http"
 	"strconv"
@@ -48,7 +49,7 @@ func (app *application) createCharacterHandler(w http.ResponseWriter, r *http.Re
 		OriginStatus: input.OriginStatus,
 	}
 
-	err = app.models.Characters.Insert(character)
+	err = app.models.Characters.Insert(r.Context(), character)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 		return
@@ -67,7 +68,7 @@ func (app *application) getCharacterHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	character, err := app.models.Characters.Get(id)
+	character, err := app.models.Characters.Get(r.Context(), id)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
@@ -86,12 +87,20 @@ func (app *application) updateCharacterHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	character, err := app.models.Characters.Get(id)
+	character, err := app.models.Characters.Get(r.Context(), id)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
 	}
 
+	if !app.authorize(w, r, actionUpdateCharacter, policy.Resource{
+		Kind:  "character",
+		ID:    int64(character.ID),
+		House: character.House,
+	}) {
+		return
+	}
+
 	var input struct {
 		FirstName *string `json:"FirstName"`
 		LastName  *string `json:"LastName"`
@@ -111,7 +120,7 @@ func (app *application) updateCharacterHandler(w http.ResponseWriter, r *http.Re
 		character.LastName = *input.LastName
 	}
 
-	err = app.models.Characters.Update(character)
+	err = app.models.Characters.Update(r.Context(), character)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 	}
@@ -129,7 +138,7 @@ func (app *application) deleteCharacterHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
  
-	err = app.models.Characters.Delete(id)
+	err = app.models.Characters.Delete(r.Context(), id)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 	}
@@ -146,7 +155,7 @@ func (app *application) getByHouseHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	characters, err := app.models.Characters.GetByHouse(house)
+	characters, err := app.models.Characters.GetByHouse(r.Context(), house)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Fail, please try again.")
 		return
@@ -156,7 +165,7 @@ func (app *application) getByHouseHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (app *application) getByLastNameHandler(w http.ResponseWriter, r *http.Request) {
-	characters, err := app.models.Characters.GetByLastName()
+	characters, err := app.models.Characters.GetByLastName(r.Context())
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Fail, please try again.")
 		return
@@ -181,7 +190,7 @@ func (app *application) getCharactersPaginationHandler(w http.ResponseWriter, r
 		return
 	}
 
-	characters, err := app.models.Characters.GetCharactersPagination(limit, offset)
+	characters, err := app.models.Characters.GetCharactersPagination(r.Context(), limit, offset)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Fail, please try again.")
 		return
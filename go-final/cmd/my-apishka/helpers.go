@@ -1,10 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base32"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -161,6 +164,31 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// sensitiveQueryParams are the query string parameters which carry credentials: the token of an
+// export download link, and the code and state of an OIDC callback.
+var sensitiveQueryParams = []string{"token", "code", "state"}
+
+// loggedURL returns the request URL as it may be written to the logs, with the values of
+// sensitiveQueryParams replaced. Anyone who can read the logs could use them otherwise.
+func loggedURL(r *http.Request) string {
+	u := *r.URL
+	q := u.Query()
+
+	redacted := false
+	for _, name := range sensitiveQueryParams {
+		if q.Has(name) {
+			q.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+
+	if redacted {
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String()
+}
+
 // readStrings is a helper method on application type that returns a string value from the URL query
 // string, or the provided default value if no matching key is found.
 func (app *application) readStrings(qs url.Values, key string, defaultValue string) string {
@@ -200,4 +228,63 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	// Otherwise, return the converted integer value.
 	return i
-}
\ No newline at end of file
+}
+// clientIP returns the IP address of the client which sent the request, without the port. When
+// the request comes through one of the trusted proxies, the client is the last address in
+// X-Forwarded-For which wasn't added by a trusted proxy. Addresses further left can be set by
+// the client itself, so they are never used.
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if len(app.config.proxies.trusted) == 0 || !app.trustedProxy(net.ParseIP(ip)) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+
+		ip = hop.String()
+		if !app.trustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// randomString returns n random bytes encoded as an unpadded base-32 string.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// background runs fn in a goroutine which the graceful shutdown waits for. A panic in fn is
+// logged instead of taking the whole server down.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	app.metrics.background.Inc()
+
+	go func() {
+		defer app.wg.Done()
+		defer app.metrics.background.Dec()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-final/pkg/ratelimit"
+	"go-final/pkg/scheduler"
+)
+
+// newScheduler returns the scheduler for the maintenance jobs. Every replica runs it, and the
+// advisory locks make sure each run happens on only one of them.
+func (app *application) newScheduler(db *sql.DB) *scheduler.Scheduler {
+	jobs := []scheduler.Job{
+		{
+			Name:     "purge-expired-tokens",
+			Schedule: scheduler.Every(time.Hour),
+			Jitter:   5 * time.Minute,
+			Run: app.purgeJob("expired tokens", func(ctx context.Context, now time.Time) (int64, error) {
+				return app.models.Tokens.DeleteExpired(ctx, now)
+			}),
+		},
+		{
+			Name:     "purge-revoked-jwts",
+			Schedule: scheduler.Every(time.Hour),
+			Jitter:   5 * time.Minute,
+			Run: app.purgeJob("expired JWT revocations", func(ctx context.Context, now time.Time) (int64, error) {
+				return app.models.Tokens.DeleteExpiredRevocations(ctx, now)
+			}),
+		},
+		{
+			Name:     "purge-deleted-users",
+			Schedule: scheduler.MustParse("@hourly"),
+			Jitter:   5 * time.Minute,
+			Run: app.purgeJob("deleted accounts", func(ctx context.Context, now time.Time) (int64, error) {
+				return app.models.Users.DeleteScheduled(ctx, now)
+			}),
+		},
+		{
+			Name:     "purge-data-exports",
+			Schedule: scheduler.Every(time.Hour),
+			Jitter:   5 * time.Minute,
+			Run: app.purgeJob("data exports", func(ctx context.Context, now time.Time) (int64, error) {
+				return app.models.Exports.DeleteFinishedBefore(ctx, now.Add(-exportDownloadTTL))
+			}),
+		},
+	}
+
+	if app.config.users.unactivatedTTL > 0 {
+		jobs = append(jobs, scheduler.Job{
+			Name:     "purge-unactivated-users",
+			Schedule: scheduler.MustParse("30 3 * * *"),
+			Jitter:   10 * time.Minute,
+			Run: app.purgeJob("unactivated accounts", func(ctx context.Context, now time.Time) (int64, error) {
+				return app.models.Users.DeleteUnactivated(ctx, now.Add(-app.config.users.unactivatedTTL))
+			}),
+		})
+	}
+
+	if app.config.limiter.store == "postgres" {
+		rateLimits := ratelimit.NewPostgres(db, app.config.limiter.dbTimeout)
+		jobs = append(jobs, scheduler.Job{
+			Name:     "purge-rate-limits",
+			Schedule: scheduler.Every(10 * time.Minute),
+			Jitter:   time.Minute,
+			Run: app.purgeJob("rate limit windows", func(ctx context.Context, _ time.Time) (int64, error) {
+				return rateLimits.Cleanup(ctx)
+			}),
+		})
+	}
+
+	return scheduler.New(scheduler.NewPostgresLocker(db), app.logger, jobs...)
+}
+
+// purgeJob wraps a function deleting stale rows as a job which logs how many were deleted.
+func (app *application) purgeJob(what string, purge func(ctx context.Context, now time.Time) (int64, error)) func(context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := purge(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			app.logger.PrintInfo("deleted "+what, map[string]string{"count": strconv.FormatInt(n, 10)})
+		}
+
+		return nil
+	}
+}
+
+// listJobsHandler returns the state of the maintenance jobs as seen by this replica.
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.scheduler == nil {
+		app.errorResponse(w, r, http.StatusNotFound, "the scheduler is disabled on this instance")
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"jobs": app.scheduler.Status()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-final/pkg/lockout"
+	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/my-apishka/validator"
+)
+
+// loginKeys returns the lockout keys for a login attempt: one for the account and one for the
+// client's IP address. The IP key catches a single client trying many different accounts.
+func (app *application) loginKeys(r *http.Request, email string) (string, string) {
+	return "email:" + strings.ToLower(email), "ip:" + app.clientIP(r)
+}
+
+// accountPolicy and ipPolicy return the lockout policies from the configuration. An IP address
+// is allowed more failures than an account, since many users may share it.
+func (app *application) accountPolicy() lockout.Policy {
+	return lockout.Policy{
+		Threshold:   app.config.lockout.threshold,
+		BaseLockout: app.config.lockout.base,
+		MaxLockout:  app.config.lockout.max,
+		Window:      24 * time.Hour,
+	}
+}
+
+func (app *application) ipPolicy() lockout.Policy {
+	policy := app.accountPolicy()
+	policy.Threshold = app.config.lockout.ipThreshold
+	return policy
+}
+
+// checkLoginAllowed sends a 429 Too Many Requests response and returns false if either the
+// account or the client is currently locked out.
+func (app *application) checkLoginAllowed(w http.ResponseWriter, r *http.Request, email string) bool {
+	accountKey, ipKey := app.loginKeys(r, email)
+
+	wait, err := app.lockout.Check(r.Context(), accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return false
+	}
+
+	return true
+}
+
+// recordLoginFailure counts a failed login for the account and the client, and logs it when one
+// of them gets locked.
+func (app *application) recordLoginFailure(r *http.Request, email string) {
+	accountKey, ipKey := app.loginKeys(r, email)
+
+	// The failure is recorded even if the client hangs up, so that dropping the connection as
+	// soon as the password has been sent doesn't get around the lockout.
+	ctx := context.WithoutCancel(r.Context())
+
+	for key, policy := range map[string]lockout.Policy{
+		accountKey: app.accountPolicy(),
+		ipKey:      app.ipPolicy(),
+	} {
+		attempts, locked, err := app.lockout.Fail(ctx, key, policy)
+		if err != nil {
+			app.logError(r, err)
+			continue
+		}
+
+		if locked {
+			app.logger.PrintInfo("login locked out", traceProperties(r.Context(), map[string]string{
+				"key":          key,
+				"failures":     strconv.Itoa(attempts.Failures),
+				"locked_until": attempts.LockedUntil.UTC().Format(time.RFC3339),
+			}))
+		}
+	}
+}
+
+// recordLoginSuccess clears the failures of the account. The IP address keeps its count, so a
+// client can't reset it by logging into an account of its own in between guesses.
+func (app *application) recordLoginSuccess(r *http.Request, email string) {
+	accountKey, _ := app.loginKeys(r, email)
+
+	if err := app.lockout.Reset(r.Context(), accountKey); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// unlockLoginHandler lets an admin lift the lockout of an account, an IP address, or both.
+func (app *application) unlockLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Email != "" || input.IP != "", "email", "email or ip must be provided")
+	if input.Email != "" {
+		model.ValidateEmail(v, input.Email)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var keys []string
+	if input.Email != "" {
+		keys = append(keys, "email:"+strings.ToLower(input.Email))
+	}
+	if input.IP != "" {
+		keys = append(keys, "ip:"+input.IP)
+	}
+
+	for _, key := range keys {
+		if err := app.lockout.Reset(r.Context(), key); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.logger.PrintInfo("login lockout lifted", traceProperties(r.Context(), map[string]string{
+		"keys":     strings.Join(keys, ","),
+		"admin_id": strconv.FormatInt(app.contextGetUser(r).ID, 10),
+	}))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "lockout successfully lifted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go-final/pkg/jsonlog"
+)
+
+// logLevelHandler shows the minimum log level on GET, and changes it on PUT with a body such as
+// {"level": "debug"}. It is served on the admin port only.
+func (app *application) logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var input struct {
+			Level string `json:"level"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		level, err := jsonlog.ParseLevel(input.Level)
+		if err != nil {
+			app.failedValidationResponse(w, r, map[string]string{"level": "must be debug, info, warn, error, fatal or off"})
+			return
+		}
+
+		app.setLogLevel(level)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		app.methodNotAllowedResponse(w, r)
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": app.logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// toggleDebugOnHangup switches between the DEBUG level and the configured level every time the
+// process receives SIGHUP, for when the admin port isn't reachable.
+func (app *application) toggleDebugOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	app.toggleDebugOn(hangup)
+}
+
+// toggleDebugOn switches the log level for every signal received, until signals is closed.
+func (app *application) toggleDebugOn(signals <-chan os.Signal) {
+	for range signals {
+		if app.logger.Level() == jsonlog.LevelDebug {
+			app.setLogLevel(app.config.log.level)
+		} else {
+			app.setLogLevel(jsonlog.LevelDebug)
+		}
+	}
+}
+
+// setLogLevel changes the minimum log level. The change is logged while the more verbose of the
+// two levels is in effect, so that it shows up whenever either level includes INFO.
+func (app *application) setLogLevel(level jsonlog.Level) {
+	previous := app.logger.Level()
+	if level == previous {
+		return
+	}
+
+	if level.Less(previous) {
+		app.logger.SetLevel(level)
+	}
+
+	app.logger.Info("log level changed", "from", previous.String(), "to", level.String())
+	app.logger.SetLevel(level)
+}
+
+// newLogOutput returns where log entries are written: stdout, and the log file if there is one.
+// Unless the buffer is disabled, the entries are written through a queue, which is returned
+// too.
+func newLogOutput(cfg config) (io.Writer, *jsonlog.Async, error) {
+	var out io.Writer = os.Stdout
+
+	if cfg.log.file != "" {
+		file, err := jsonlog.NewRotatingFile(cfg.log.file, int64(cfg.log.maxSize)<<20, cfg.log.maxBackups, cfg.log.compress)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = jsonlog.NewMulti(os.Stdout, file)
+	}
+
+	if cfg.log.buffer <= 0 {
+		return out, nil, nil
+	}
+
+	queue := jsonlog.NewAsync(out, cfg.log.buffer)
+	return queue, queue, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"go-final/pkg/jsonlog"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantLevel  jsonlog.Level
+	}{
+		{"show", http.MethodGet, "", http.StatusOK, jsonlog.LevelInfo},
+		{"change", http.MethodPut, `{"level": "debug"}`, http.StatusOK, jsonlog.LevelDebug},
+		{"change to warn", http.MethodPut, `{"level": "WARN"}`, http.StatusOK, jsonlog.LevelWarn},
+		{"unknown level", http.MethodPut, `{"level": "verbose"}`, http.StatusUnprocessableEntity, jsonlog.LevelInfo},
+		{"malformed body", http.MethodPut, `{"level": `, http.StatusBadRequest, jsonlog.LevelInfo},
+		{"unknown field", http.MethodPut, `{"level": "debug", "for": "1h"}`, http.StatusBadRequest, jsonlog.LevelInfo},
+		{"other method", http.MethodPost, `{"level": "debug"}`, http.StatusMethodNotAllowed, jsonlog.LevelInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newMockApplication(t)
+
+			r := httptest.NewRequest(tt.method, "/log/level", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+
+			app.logLevelHandler(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if got := app.logger.Level(); got != tt.wantLevel {
+				t.Errorf("got level %s; want %s", got, tt.wantLevel)
+			}
+			if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), `"level": "`+tt.wantLevel.String()+`"`) {
+				t.Errorf("got body %s; want the level %s", rr.Body.String(), tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestSetLogLevelLogsChange(t *testing.T) {
+	tests := []struct {
+		from, to jsonlog.Level
+		wantLog  bool
+	}{
+		{jsonlog.LevelInfo, jsonlog.LevelDebug, true},
+		{jsonlog.LevelInfo, jsonlog.LevelError, true},
+		// Neither level includes INFO.
+		{jsonlog.LevelError, jsonlog.LevelWarn, false},
+		{jsonlog.LevelError, jsonlog.LevelDebug, true},
+		{jsonlog.LevelInfo, jsonlog.LevelInfo, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from.String()+" to "+tt.to.String(), func(t *testing.T) {
+			app, _, logs := newMockApplication(t)
+			app.logger.SetLevel(tt.from)
+
+			app.setLogLevel(tt.to)
+
+			if got := app.logger.Level(); got != tt.to {
+				t.Errorf("got level %s; want %s", got, tt.to)
+			}
+			if got := strings.Contains(logs.String(), "log level changed"); got != tt.wantLog {
+				t.Errorf("got the change logged %t; want %t: %s", got, tt.wantLog, logs.String())
+			}
+		})
+	}
+}
+
+func TestToggleDebugOnHangup(t *testing.T) {
+	for signals, want := range []jsonlog.Level{jsonlog.LevelWarn, jsonlog.LevelDebug, jsonlog.LevelWarn, jsonlog.LevelDebug} {
+		app, _, _ := newMockApplication(t)
+		app.config.log.level = jsonlog.LevelWarn
+		app.logger.SetLevel(jsonlog.LevelWarn)
+
+		hangup := make(chan os.Signal, signals)
+		for i := 0; i < signals; i++ {
+			hangup <- syscall.SIGHUP
+		}
+		close(hangup)
+
+		app.toggleDebugOn(hangup)
+
+		if got := app.logger.Level(); got != want {
+			t.Errorf("after %d signals: got level %s; want %s", signals, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"go-final/pkg/metrics"
+
+	"github.com/gorilla/mux"
+)
+
+// appMetrics are the metrics served on the admin port.
+type appMetrics struct {
+	registry   *metrics.Registry
+	requests   *metrics.Counter
+	duration   *metrics.Histogram
+	inFlight   *metrics.Gauge
+	background *metrics.Gauge
+}
+
+// newMetrics registers the request metrics, and gauges which read the database pool statistics
+// and goroutine counts whenever the metrics are scraped.
+func newMetrics(db *sql.DB) *appMetrics {
+	r := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry:   r,
+		requests:   r.NewCounter("http_requests_total", "HTTP requests by route template, method and status class.", "route", "method", "status"),
+		duration:   r.NewHistogram("http_request_duration_seconds", "HTTP request latencies by route template and method.", metrics.DefBuckets, "route", "method"),
+		inFlight:   r.NewGauge("http_requests_in_flight", "HTTP requests currently being served."),
+		background: r.NewGauge("app_background_tasks", "Background tasks, such as data exports, currently running."),
+	}
+
+	r.NewGaugeFunc("go_goroutines", "Goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+
+	stats := func(f func(sql.DBStats) float64) func() float64 {
+		return func() float64 { return f(db.Stats()) }
+	}
+
+	r.NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	r.NewGaugeFunc("db_open_connections", "Established connections, both in use and idle.",
+		stats(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	r.NewGaugeFunc("db_in_use_connections", "Connections currently in use.",
+		stats(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	r.NewGaugeFunc("db_idle_connections", "Idle connections.",
+		stats(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	r.NewCounterFunc("db_wait_count_total", "Connections waited for.",
+		stats(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	r.NewCounterFunc("db_wait_duration_seconds_total", "Time spent waiting for connections.",
+		stats(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	r.NewCounterFunc("db_max_idle_closed_total", "Connections closed because of the idle connection limit.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	r.NewCounterFunc("db_max_idle_time_closed_total", "Connections closed because of the idle time limit.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	r.NewCounterFunc("db_max_lifetime_closed_total", "Connections closed because of the connection lifetime limit.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+
+	return m
+}
+
+// collectMetrics counts every request and records its latency, labelled with the template of
+// the route it matched rather than its URL, so that /characters/1 and /characters/2 are one
+// series. It wraps everything else, so rate limited requests and recovered panics are counted
+// too.
+func (app *application) collectMetrics(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := routeTemplate(router, r)
+
+		app.metrics.inFlight.Inc()
+		defer app.metrics.inFlight.Dec()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		app.metrics.requests.Inc(route, r.Method, strconv.Itoa(sw.status/100)+"xx")
+		app.metrics.duration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+// routeTemplate returns the path template of the route the request matches, or "unmatched".
+func routeTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if router.Match(r, &match) && match.Route != nil {
+		if template, err := match.Route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+
+	return "unmatched"
+}
+
+// statusWriter records the status code and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
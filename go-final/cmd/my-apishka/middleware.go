@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -11,11 +12,91 @@ import (
 	"gorm.io/gorm"
 )
 
+// recoverPanic turns a panic in any later handler into a 500 Internal Server Error response, so
+// the client gets a proper error instead of a dropped connection. The log entry carries the
+// panic value and, through jsonlog, the stack of the panicking goroutine.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The writer tells whether the handler got as far as sending the response.
+		sw, ok := w.(*statusWriter)
+		if !ok {
+			sw = &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		}
+
+		defer func() {
+			if rec := recover(); rec != nil {
+				// net/http uses this panic to abort a response on purpose.
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+
+				err := fmt.Errorf("panic: %v", rec)
+
+				// An error response can't follow a response which has been started, and the
+				// client mustn't take the partial one for a complete response. Log the panic
+				// and make net/http drop the connection instead.
+				if sw.wroteHeader {
+					app.logError(r, err)
+					panic(http.ErrAbortHandler)
+				}
+
+				// The handler may have left the connection in an unknown state, so make Go's
+				// HTTP server close it once the response has been sent.
+				w.Header().Set("Connection", "close")
+				app.serverErrorResponse(w, r, err)
+			}
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// enableCORS lets browser frontends on the trusted origins call the API. Requests from other
+// origins are served as before, but without the headers the browser needs to let the page read
+// the response.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on these request headers, so caches must keep them apart.
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" && app.trustedOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, X-Request-ID")
+
+			// A preflight request is an OPTIONS request with Access-Control-Request-Method set.
+			// It is answered here, since the router has no OPTIONS routes and would send 404 or 405.
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Expected-Version, X-Request-ID")
+				w.Header().Set("Access-Control-Max-Age", "600")
+
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trustedOrigin reports whether the origin is one of the configured trusted origins.
+func (app *application) trustedOrigin(origin string) bool {
+	for _, trusted := range app.config.cors.trustedOrigins {
+		if origin == trusted {
+			return true
+		}
+	}
+	return false
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any caches
 		// that the response may vary based on the value of the Authorization header in the request.
-		w.Header().Set("Vary", "Authorization")
+		w.Header().Add("Vary", "Authorization")
 
 		// Retrieve the value of the Authorization header from teh request. This will return the
 		// empty string "" if there is no such header found.
@@ -35,6 +116,28 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// isn't in the expected format we return a 401 Unauthorized response using the
 		// invalidAuthenticationTokenResponse helper.
 		headerParts := strings.Split(authorizationHeader, " ")
+
+		// Scripts and other services authenticate with "ApiKey <key>" instead.
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			authenticated, err := app.authenticateAPIKey(r, headerParts[1])
+			if err != nil {
+				switch {
+				case errors.Is(err, gorm.ErrRecordNotFound):
+					app.invalidAPIKeyResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			if !app.checkAccountStatus(w, r, app.contextGetUser(authenticated)) {
+				return
+			}
+
+			next.ServeHTTP(w, authenticated)
+			return
+		}
+
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -43,6 +146,25 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Extract the actual authentication toekn from the header parts
 		token := headerParts[1]
 
+		// In JWT mode access tokens are verified locally, without a database round-trip.
+		// Opaque tokens are still accepted below.
+		if app.jwt != nil && strings.Count(token, ".") == 2 {
+			authenticated, err := app.authenticateJWT(r, token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			// The account status comes from the denylist, which learns of suspensions, forced
+			// password resets and deletions from Postgres.
+			if !app.checkAccountStatus(w, r, app.contextGetUser(authenticated)) {
+				return
+			}
+
+			next.ServeHTTP(w, authenticated)
+			return
+		}
+
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 
@@ -55,7 +177,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		// Retrieve the details of the user associated with the authentication token.
 		// call invalidAuthenticationTokenResponse if no matching record was found.
-		user, err := app.models.Users.GetForToken(model.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), model.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, gorm.ErrRecordNotFound):
@@ -66,14 +188,48 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Suspending or deleting a user revokes their tokens, but a token could have been issued
+		// while the change was being saved.
+		if !app.checkAccountStatus(w, r, user) {
+			return
+		}
+
+		// Record when and from where the session was last used. This is throttled per token so
+		// that we don't write to the tokens table on every request.
+		if app.sessions.due(token) {
+			err = app.models.Tokens.Touch(r.Context(), token, r.UserAgent(), app.clientIP(r))
+			if err != nil {
+				app.logError(r, err)
+			}
+		}
+
 		// Call the contextSetUser healer to add the user information to the request context.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetAuth(r, &authInfo{token: token})
 
 		// Call next handler in chain
 		next.ServeHTTP(w, r)
 	})
 }
 
+// checkAccountStatus sends an error response and returns false if the user's account is
+// suspended, scheduled for deletion, or has to reset its password.
+func (app *application) checkAccountStatus(w http.ResponseWriter, r *http.Request, user *model.User) bool {
+	switch {
+	case user.IsSuspended():
+		app.accountSuspendedResponse(w, r)
+		return false
+	case user.DeletionScheduledAt != nil:
+		app.accountPendingDeletionResponse(w, r)
+		return false
+	case user.PasswordResetRequired:
+		app.passwordResetRequiredResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
 //requireAuthenticatedUser checks that the user is not anonymous (i.e., they are authenticated).
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -110,21 +266,35 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
-func (app *application) requirePermissions(code string, next http.HandlerFunc) http.HandlerFunc {
+// requireLoggedInUser checks that the user is activated and authenticated with their own login,
+// a session token or a JWT, rather than an API key. Routes which manage credentials use it, so
+// that a key, and in particular a scoped one, can't be used to mint broader credentials or to
+// take over the account.
+func (app *application) requireLoggedInUser(next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Retrieve the user from the request context.
-		user := app.contextGetUser(r)
+		if auth := app.contextGetAuth(r); auth != nil && auth.apiKey != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
 
-		// Get the slice of permission for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireActivatedUser(fn)
+}
+
+func (app *application) requirePermissions(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Get the slice of permission for the user.
+		permissions, err := app.userPermissions(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		// Check if the slice includes the required permission. If it doesn't, then return a 403
+		// Check if the request may use the required permission. If it can't, then return a 403
 		// Forbidden response.
-		if !permissions.Include(code) {
+		if !app.hasPermission(r, permissions, code) {
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -135,4 +305,28 @@ func (app *application) requirePermissions(code string, next http.HandlerFunc) h
 
 	// Wrap this with the requireActivatedUser middleware before returning
 	return app.requireActivatedUser(fn)
-}
\ No newline at end of file
+}
+
+// userPermissions returns the permission codes of the request's user. A JWT already carries
+// them, otherwise they come from the permission cache.
+func (app *application) userPermissions(r *http.Request) (model.Permissions, error) {
+	if auth := app.contextGetAuth(r); auth != nil && auth.claims != nil {
+		return auth.claims.Permissions, nil
+	}
+
+	return app.permissionCache.get(r.Context(), app.contextGetUser(r).ID)
+}
+
+// hasPermission reports whether the request may use a permission code: the user has to hold
+// it, and a scoped API key can only use the permissions it was created with.
+func (app *application) hasPermission(r *http.Request, permissions model.Permissions, code string) bool {
+	if !permissions.Include(code) {
+		return false
+	}
+
+	if auth := app.contextGetAuth(r); auth != nil && auth.apiKey != nil && auth.apiKey.Permissions != nil {
+		return auth.apiKey.Permissions.Include(code)
+	}
+
+	return true
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-final/pkg/my-apishka/model"
+
+	"github.com/gorilla/mux"
+)
+
+// servePanicking serves a request with handler behind recoverPanic, as routes does, and returns
+// the response and the value of any panic which got past recoverPanic.
+func servePanicking(app *application, handler http.HandlerFunc) (rr *httptest.ResponseRecorder, rec interface{}) {
+	rr = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/characters", nil)
+
+	// authenticate only sets the user on its own copy of the request.
+	authenticated := func(w http.ResponseWriter, r *http.Request) {
+		handler(w, withUser(app, r, &model.User{ID: 7, Activated: true}))
+	}
+
+	defer func() {
+		rec = recover()
+	}()
+
+	app.logRequests(mux.NewRouter(), app.recoverPanic(http.HandlerFunc(authenticated))).ServeHTTP(rr, r)
+
+	return rr, nil
+}
+
+func TestRecoverPanic(t *testing.T) {
+	app, logs := newTestApplication(t, nil)
+
+	rr, rec := servePanicking(app, func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	if rec != nil {
+		t.Fatalf("got panic %v; want it recovered", rec)
+	}
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if rr.Header().Get("Connection") != "close" {
+		t.Errorf("got Connection %q; want close", rr.Header().Get("Connection"))
+	}
+	if !strings.Contains(rr.Body.String(), "the server encountered a problem") {
+		t.Errorf("got body %q; want the server error message", rr.Body.String())
+	}
+
+	for _, want := range []string{"panic: boom", `"user_id":"7"`, `"request_id":"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("got logs %q; want them to contain %s", logs.String(), want)
+		}
+	}
+}
+
+func TestRecoverPanicAfterHeaders(t *testing.T) {
+	app, logs := newTestApplication(t, nil)
+
+	rr, rec := servePanicking(app, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"characters": [`))
+		panic("boom")
+	})
+
+	// net/http drops the connection, so the client can't take the partial response for a
+	// complete one.
+	if rec != http.ErrAbortHandler {
+		t.Errorf("got panic %v; want http.ErrAbortHandler", rec)
+	}
+	if rr.Body.String() != `{"characters": [` {
+		t.Errorf("got body %q; want only what the handler wrote", rr.Body.String())
+	}
+
+	for _, want := range []string{"panic: boom", `"user_id":"7"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("got logs %q; want them to contain %s", logs.String(), want)
+		}
+	}
+}
+
+func TestRecoverPanicAbortHandler(t *testing.T) {
+	app, logs := newTestApplication(t, nil)
+
+	rr, rec := servePanicking(app, func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	if rec != http.ErrAbortHandler {
+		t.Errorf("got panic %v; want http.ErrAbortHandler passed on", rec)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("got body %q; want none", rr.Body.String())
+	}
+	if logs.Len() != 0 {
+		t.Errorf("got logs %q; want nothing logged", logs.String())
+	}
+}
+
+func TestEnableCORS(t *testing.T) {
+	const trusted = "https://hogwarts.example"
+
+	tests := []struct {
+		name          string
+		method        string
+		origin        string
+		preflight     bool
+		wantStatus    int
+		wantAllowOrig string
+	}{
+		{"no origin", http.MethodDelete, "", false, http.StatusUnauthorized, ""},
+		{"trusted origin", http.MethodDelete, trusted, false, http.StatusUnauthorized, trusted},
+		{"untrusted origin", http.MethodDelete, "https://durmstrang.example", false, http.StatusUnauthorized, ""},
+		{"origin differing in scheme", http.MethodDelete, "http://hogwarts.example", false, http.StatusUnauthorized, ""},
+		// The router has no OPTIONS routes, so only the middleware can answer a preflight. Being
+		// a subrouter route, /characters/{id} answers other methods with 404 rather than 405.
+		{"preflight from a trusted origin", http.MethodOptions, trusted, true, http.StatusNoContent, trusted},
+		{"preflight from an untrusted origin", http.MethodOptions, "https://durmstrang.example", true, http.StatusNotFound, ""},
+		{"OPTIONS which isn't a preflight", http.MethodOptions, trusted, false, http.StatusNotFound, trusted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newMockApplication(t)
+			app.config.cors.trustedOrigins = []string{"https://beauxbatons.example", trusted}
+
+			r := httptest.NewRequest(tt.method, "/api/v1/characters/1", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+			}
+			rr := httptest.NewRecorder()
+
+			app.routes().ServeHTTP(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllowOrig {
+				t.Errorf("got Access-Control-Allow-Origin %q; want %q", got, tt.wantAllowOrig)
+			}
+
+			wantMethods := ""
+			if tt.wantStatus == http.StatusNoContent {
+				wantMethods = "OPTIONS, GET, POST, PUT, PATCH, DELETE"
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Methods"); got != wantMethods {
+				t.Errorf("got Access-Control-Allow-Methods %q; want %q", got, wantMethods)
+			}
+
+			// Whatever the origin, caches must not serve the response to another one.
+			vary := rr.Header().Values("Vary")
+			for _, want := range []string{"Origin", "Access-Control-Request-Method"} {
+				if !contains(vary, want) {
+					t.Errorf("got Vary %q; want %s in it", vary, want)
+				}
+			}
+			if tt.wantStatus != http.StatusNoContent && !contains(vary, "Authorization") {
+				t.Errorf("got Vary %q; want Authorization next to Origin", vary)
+			}
+		})
+	}
+}
+
+// contains reports whether values includes value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
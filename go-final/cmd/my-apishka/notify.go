@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go-final/pkg/jsonlog"
+
+	"github.com/lib/pq"
+)
+
+// notifyListener receives the Postgres notifications of one channel in a background goroutine.
+type notifyListener struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// listenNotify subscribes to a notification channel and passes every notification to handle
+// until the listener is stopped. A nil notification means the connection was re-established,
+// and that notifications sent in the meantime were lost.
+func listenNotify(dsn string, logger *jsonlog.Logger, channel string, handle func(*pq.Notification)) (*notifyListener, error) {
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.PrintError(err, map[string]string{"listener": channel})
+		}
+	})
+
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &notifyListener{cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(l.done)
+		defer listener.Close()
+
+		// Check the connection now and then, so that a dead one is noticed even when there are
+		// no notifications.
+		ping := time.NewTicker(90 * time.Second)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case n := <-listener.Notify:
+				handle(n)
+
+			case <-ping.C:
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return l, nil
+}
+
+// stop ends the listener's goroutine and closes its connection. It does nothing on a nil
+// listener, so callers needn't check whether they started one.
+func (l *notifyListener) stop() {
+	if l == nil {
+		return
+	}
+
+	l.cancel()
+	<-l.done
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/oidc"
+
+	"gorm.io/gorm"
+)
+
+// oidcCookie carries the state, nonce and PKCE verifier of a login in progress from the login
+// redirect to the callback. It is HttpOnly and scoped to the OIDC endpoints, and keeping it on
+// the client means any replica can handle the callback.
+const oidcCookie = "oidc_login"
+
+type oidcLoginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// oidcLoginHandler starts a login at the external provider by redirecting the user to it.
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var login oidcLoginState
+	for _, value := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		v, err := oidc.NewVerifier()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		*value = v
+	}
+
+	authURL, err := app.oidc.AuthCodeURL(r.Context(), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	js, err := json.Marshal(login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(js),
+		Path:     "/api/v1/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   app.config.env != "development",
+		// Lax still sends the cookie on the top-level redirect back from the provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallbackHandler completes a login at the external provider. It validates the ID token,
+// finds or creates the matching user and then logs them in like a password login would.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	if e := qs.Get("error"); e != "" {
+		app.errorResponse(w, r, http.StatusUnauthorized, "login at the identity provider failed: "+e)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("no login in progress"))
+		return
+	}
+
+	// The cookie is only good for a single callback.
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/api/v1/oidc", MaxAge: -1})
+
+	var login oidcLoginState
+	js, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err == nil {
+		err = json.Unmarshal(js, &login)
+	}
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid login state"))
+		return
+	}
+
+	// The state ties the callback to the browser that started the login, which stops an attacker
+	// from logging a victim into the attacker's account.
+	if subtle.ConstantTimeCompare([]byte(qs.Get("state")), []byte(login.State)) != 1 {
+		app.badRequestResponse(w, r, errors.New("state mismatch"))
+		return
+	}
+
+	token, err := app.oidc.Exchange(r.Context(), qs.Get("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidIDToken), errors.Is(err, oidc.ErrNonceMismatch):
+			app.logError(r, err)
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if token.Email == "" || !token.EmailVerified {
+		app.errorResponse(w, r, http.StatusForbidden, "the identity provider did not confirm a verified email address")
+		return
+	}
+
+	user, err := app.userForIdentity(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateEmail), errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.completeLogin(w, r, user, false)
+}
+
+// userForIdentity returns the user linked to the identity in the ID token. An identity seen for
+// the first time is linked to the user with the same verified email address, or to a new,
+// already activated user if there is none. The token's address must have been verified by the
+// provider.
+func (app *application) userForIdentity(ctx context.Context, token *oidc.IDToken) (*model.User, error) {
+	provider := app.config.oidc.provider
+
+	user, err := app.models.Identities.GetUser(ctx, provider, token.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	user, err = app.models.Users.GetByEmail(ctx, token.Email)
+	switch {
+	case err == nil:
+		// The provider has verified the address, which is what our activation token does too.
+		// But anyone can register an address they don't own and never activate it, so the
+		// password and sessions of an unactivated account are discarded before the owner of
+		// the address takes it over. Otherwise whoever registered it could still log in.
+		if !user.Activated {
+			if err := setRandomPassword(user); err != nil {
+				return nil, err
+			}
+			user.Activated = true
+
+			if err := app.models.Users.Update(ctx, user); err != nil {
+				return nil, err
+			}
+
+			if err := app.revokeUserSessions(ctx, user.ID); err != nil {
+				return nil, err
+			}
+		}
+
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		user, err = app.createUserForIdentity(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, err
+	}
+
+	err = app.models.Identities.Link(ctx, provider, token.Subject, user.ID, token.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (app *application) createUserForIdentity(ctx context.Context, token *oidc.IDToken) (*model.User, error) {
+	username := token.PreferredUsername
+	if username == "" {
+		username = token.Name
+	}
+	if username == "" {
+		username, _, _ = strings.Cut(token.Email, "@")
+	}
+
+	user := &model.User{
+		Username:  username,
+		Email:     token.Email,
+		Activated: true,
+	}
+
+	if err := setRandomPassword(user); err != nil {
+		return nil, err
+	}
+
+	if err := app.models.Users.Insert(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// setRandomPassword gives a user who logs in through the provider a random password that nobody
+// knows. The column can't be empty, and no password that was set before must keep working.
+func setRandomPassword(user *model.User) error {
+	password, err := randomString(32)
+	if err != nil {
+		return err
+	}
+
+	return user.Password.Set(password)
+}
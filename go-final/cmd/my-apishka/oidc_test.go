@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/oidc"
+	"go-final/pkg/oidc/oidctest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newOIDCProvider starts a stand-in provider for app and configures app to log in with it.
+func newOIDCProvider(t *testing.T, app *application) *oidctest.Server {
+	t.Helper()
+
+	provider, err := oidctest.NewServer("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(provider.Close)
+
+	app.config.oidc.provider = "test"
+	app.oidc = oidc.NewClient(provider.Issuer(), "api", "secret", "http://api.example.com/api/v1/oidc/callback")
+
+	return provider
+}
+
+// oidcLogin goes through a login at the provider like a browser would, and returns the response
+// to the callback.
+func oidcLogin(t *testing.T, app *application) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	app.oidcLoginHandler(rr, httptest.NewRequest(http.MethodGet, "/api/v1/oidc/login", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("got status %d starting the login; want %d: %s", rr.Code, http.StatusFound, rr.Body.String())
+	}
+	cookies := rr.Result().Cookies()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	res, err := client.Get(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("got status %d from the provider; want %d", res.StatusCode, http.StatusFound)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, res.Header.Get("Location"), nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	rr = httptest.NewRecorder()
+
+	app.oidcCallbackHandler(rr, r)
+
+	return rr
+}
+
+// expectLogin sets up the queries of completeLogin for a user without 2FA.
+func expectLogin(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM users_totp").WillReturnRows(sqlmock.NewRows([]string{"user_id", "secret", "confirmed", "last_step"}))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// otherPassword matches a password hash other than the one userValues returns.
+type otherPassword struct{}
+
+func (otherPassword) Match(v driver.Value) bool {
+	hash, ok := v.([]byte)
+	return ok && len(hash) > 0 && !bytes.Equal(hash, []byte("-"))
+}
+
+func TestOIDCUnverifiedEmail(t *testing.T) {
+	app, _, _ := newMockApplication(t)
+	provider := newOIDCProvider(t, app)
+	provider.Identity = oidctest.Identity{Subject: "1234", Email: "hermione@example.com", EmailVerified: false}
+
+	// No queries are expected: an unverified address is refused before any account is looked at.
+	rr := oidcLogin(t, app)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("got status %d; want %d: %s", rr.Code, http.StatusForbidden, rr.Body.String())
+	}
+}
+
+func TestOIDCLinking(t *testing.T) {
+	identity := oidctest.Identity{Subject: "1234", Email: "hermione@example.com", EmailVerified: true, Name: "hermione"}
+	userRow := func(activated bool) *sqlmock.Rows {
+		user := &model.User{ID: 3, CreatedAt: time.Now(), Username: "hermione", Email: identity.Email, Activated: activated, Version: 1}
+		return sqlmock.NewRows(userColumns).AddRow(userValues(user)...)
+	}
+
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "identity already linked",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("JOIN user_identities").WithArgs("test", "1234").WillReturnRows(userRow(true))
+			},
+		},
+		{
+			name: "activated account",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("JOIN user_identities").WillReturnRows(sqlmock.NewRows(userColumns))
+				mock.ExpectQuery("WHERE Email").WithArgs(identity.Email).WillReturnRows(userRow(true))
+				mock.ExpectExec("INSERT INTO user_identities").WithArgs("test", "1234", int64(3), identity.Email).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			// Someone else may have registered the address: their password and sessions go.
+			name: "unactivated account",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("JOIN user_identities").WillReturnRows(sqlmock.NewRows(userColumns))
+				mock.ExpectQuery("WHERE Email").WithArgs(identity.Email).WillReturnRows(userRow(false))
+				mock.ExpectQuery("UPDATE users").
+					WithArgs("hermione", identity.Email, otherPassword{}, true, nil, false, "", "", "", nil, nil, int64(3), 1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(int64(2)))
+				for _, scope := range []string{model.ScopeAuthentication, model.ScopeRefresh, model.ScopeMFAPending} {
+					mock.ExpectExec("DELETE FROM tokens").WithArgs(scope, int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				mock.ExpectExec("INSERT INTO user_identities").WithArgs("test", "1234", int64(3), identity.Email).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "new user",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("JOIN user_identities").WillReturnRows(sqlmock.NewRows(userColumns))
+				mock.ExpectQuery("WHERE Email").WillReturnRows(sqlmock.NewRows(userColumns))
+				mock.ExpectQuery("INSERT INTO users").WithArgs("hermione", identity.Email, otherPassword{}, true).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "createdat", "version"}).AddRow(int64(3), time.Now(), int64(1)))
+				mock.ExpectExec("INSERT INTO user_identities").WithArgs("test", "1234", int64(3), identity.Email).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := newMockApplication(t)
+			provider := newOIDCProvider(t, app)
+			provider.Identity = identity
+
+			tt.expect(mock)
+			expectLogin(mock)
+
+			rr := oidcLogin(t, app)
+
+			if rr.Code != http.StatusCreated {
+				t.Errorf("got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+			}
+		})
+	}
+}
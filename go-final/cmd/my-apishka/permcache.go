@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-final/pkg/jsonlog"
+	"go-final/pkg/my-apishka/model"
+
+	"github.com/lib/pq"
+)
+
+// permissionsChannel is the Postgres notification channel on which grant changes are announced,
+// see migration 20240310120700. The payload is a user ID, or "*" if any user may be affected.
+const permissionsChannel = "permissions_changed"
+
+type permissionCacheEntry struct {
+	permissions model.Permissions
+	expires     time.Time
+}
+
+// permissionCache keeps the permission codes of recently seen users in memory, so that
+// requirePermissions doesn't query the database on every request. Entries are dropped when
+// Postgres announces a change of the user's grants, and expire after the TTL in any case, which
+// bounds how stale they can get if a notification is lost.
+type permissionCache struct {
+	ttl  time.Duration
+	load func(ctx context.Context, userID int64) (model.Permissions, error)
+	// now returns the current time, and is replaced in tests to expire entries.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[int64]permissionCacheEntry
+	// generation is bumped by every invalidation. A load which started before an invalidation
+	// may have read the old grants, so its result is not stored.
+	generation uint64
+
+	// listener is set by listen.
+	listener *notifyListener
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+func newPermissionCache(ttl time.Duration, load func(context.Context, int64) (model.Permissions, error)) *permissionCache {
+	return &permissionCache{
+		ttl:     ttl,
+		load:    load,
+		now:     time.Now,
+		entries: make(map[int64]permissionCacheEntry),
+	}
+}
+
+// get returns the permission codes of a user, loading them if they aren't cached. A cache with
+// a TTL of zero is disabled and always loads.
+func (c *permissionCache) get(ctx context.Context, userID int64) (model.Permissions, error) {
+	if c.ttl <= 0 {
+		return c.load(ctx, userID)
+	}
+
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	generation := c.generation
+	c.mu.Unlock()
+
+	if ok && now.Before(entry.expires) {
+		c.hits.Add(1)
+		return entry.permissions, nil
+	}
+
+	c.misses.Add(1)
+
+	permissions, err := c.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.entries[userID] = permissionCacheEntry{permissions: permissions, expires: now.Add(c.ttl)}
+	}
+	c.mu.Unlock()
+
+	return permissions, nil
+}
+
+// invalidate drops the cached permissions of a user.
+func (c *permissionCache) invalidate(userID int64) {
+	c.mu.Lock()
+	delete(c.entries, userID)
+	c.generation++
+	c.mu.Unlock()
+
+	c.invalidations.Add(1)
+}
+
+// flush drops all cached permissions.
+func (c *permissionCache) flush() {
+	c.mu.Lock()
+	c.entries = make(map[int64]permissionCacheEntry)
+	c.generation++
+	c.mu.Unlock()
+
+	c.invalidations.Add(1)
+}
+
+// listen subscribes to grant changes and applies them to the cache in a background goroutine,
+// until stop is called. After the connection drops the whole cache is flushed, since
+// notifications sent in the meantime are lost.
+func (c *permissionCache) listen(dsn string, logger *jsonlog.Logger) error {
+	listener, err := listenNotify(dsn, logger, permissionsChannel, func(n *pq.Notification) {
+		if n == nil || n.Extra == "*" {
+			c.flush()
+			return
+		}
+
+		userID, err := strconv.ParseInt(n.Extra, 10, 64)
+		if err != nil {
+			c.flush()
+			return
+		}
+
+		c.invalidate(userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.listener = listener
+	return nil
+}
+
+// stop ends the goroutine started by listen and closes its connection. It does nothing if the
+// cache isn't listening.
+func (c *permissionCache) stop() {
+	c.listener.stop()
+}
+
+// permissionCacheHandler reports the cache's hit and miss counters.
+func (app *application) permissionCacheHandler(w http.ResponseWriter, r *http.Request) {
+	c := app.permissionCache
+
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	hits, misses := c.hits.Load(), c.misses.Load()
+
+	var ratio float64
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"permission_cache": map[string]interface{}{
+		"ttl":           c.ttl.String(),
+		"entries":       entries,
+		"hits":          hits,
+		"misses":        misses,
+		"hit_ratio":     ratio,
+		"invalidations": c.invalidations.Load(),
+	}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+)
+
+// stubPermissions is a loader which counts its calls. Unless release is nil, every call waits
+// for a value on it after announcing itself on started.
+type stubPermissions struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *stubPermissions) load(ctx context.Context, userID int64) (model.Permissions, error) {
+	s.mu.Lock()
+	s.calls++
+	release := s.release
+	s.mu.Unlock()
+
+	if release != nil {
+		s.started <- struct{}{}
+		<-release
+	}
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	return model.Permissions{"characters:read"}, nil
+}
+
+func (s *stubPermissions) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls
+}
+
+// newStubCache returns a cache with a TTL of a minute whose clock is moved by advancing the
+// returned time.
+func newStubCache(stub *stubPermissions) (*permissionCache, *time.Time) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	c := newPermissionCache(time.Minute, stub.load)
+	c.now = func() time.Time { return now }
+
+	return c, &now
+}
+
+func TestPermissionCacheTTL(t *testing.T) {
+	stub := &stubPermissions{}
+	c, now := newStubCache(stub)
+
+	for i, step := range []struct {
+		advance   time.Duration
+		wantCalls int
+	}{
+		{0, 1},
+		{30 * time.Second, 1},
+		{29 * time.Second, 1},
+		// The entry was stored at 0s and expires a minute later.
+		{time.Second, 2},
+		{59 * time.Second, 2},
+	} {
+		*now = now.Add(step.advance)
+
+		permissions, err := c.get(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !permissions.Include("characters:read") {
+			t.Errorf("step %d: got %v; want characters:read", i, permissions)
+		}
+		if got := stub.count(); got != step.wantCalls {
+			t.Errorf("step %d: got %d loads; want %d", i, got, step.wantCalls)
+		}
+	}
+
+	if hits, misses := c.hits.Load(), c.misses.Load(); hits != 3 || misses != 2 {
+		t.Errorf("got %d hits and %d misses; want 3 and 2", hits, misses)
+	}
+}
+
+func TestPermissionCacheInvalidate(t *testing.T) {
+	stub := &stubPermissions{}
+	c, _ := newStubCache(stub)
+	ctx := context.Background()
+
+	c.get(ctx, 1)
+	c.get(ctx, 2)
+
+	c.invalidate(1)
+	c.get(ctx, 1)
+	c.get(ctx, 2)
+
+	if got := stub.count(); got != 3 {
+		t.Errorf("got %d loads after invalidating one user; want 3", got)
+	}
+
+	c.flush()
+	c.get(ctx, 1)
+	c.get(ctx, 2)
+
+	if got := stub.count(); got != 5 {
+		t.Errorf("got %d loads after a flush; want 5", got)
+	}
+	if got := c.invalidations.Load(); got != 2 {
+		t.Errorf("got %d invalidations; want 2", got)
+	}
+}
+
+func TestPermissionCacheInvalidateDuringLoad(t *testing.T) {
+	stub := &stubPermissions{started: make(chan struct{}), release: make(chan struct{})}
+	c, _ := newStubCache(stub)
+
+	done := make(chan error)
+	go func() {
+		_, err := c.get(context.Background(), 1)
+		done <- err
+	}()
+
+	// The grants change while the old ones are being read: the result must not be cached.
+	<-stub.started
+	c.invalidate(1)
+	stub.release <- struct{}{}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	_, cached := c.entries[1]
+	c.mu.Unlock()
+
+	if cached {
+		t.Error("got a load which raced an invalidation cached; want it dropped")
+	}
+
+	stub.mu.Lock()
+	stub.release = nil
+	stub.mu.Unlock()
+
+	c.get(context.Background(), 1)
+	c.get(context.Background(), 1)
+
+	if got := stub.count(); got != 2 {
+		t.Errorf("got %d loads; want 2, the second of which is cached", got)
+	}
+}
+
+func TestPermissionCacheErrors(t *testing.T) {
+	stub := &stubPermissions{err: errors.New("connection refused")}
+	c, _ := newStubCache(stub)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.get(context.Background(), 1); !errors.Is(err, stub.err) {
+			t.Fatalf("got error %v; want %v", err, stub.err)
+		}
+	}
+
+	// Failures aren't cached.
+	if got := stub.count(); got != 2 {
+		t.Errorf("got %d loads; want 2", got)
+	}
+}
+
+func TestPermissionCacheDisabled(t *testing.T) {
+	stub := &stubPermissions{}
+	c := newPermissionCache(0, stub.load)
+
+	c.get(context.Background(), 1)
+	c.get(context.Background(), 1)
+
+	if got := stub.count(); got != 2 {
+		t.Errorf("got %d loads; want 2", got)
+	}
+	if hits, misses := c.hits.Load(), c.misses.Load(); hits != 0 || misses != 0 {
+		t.Errorf("got %d hits and %d misses; want none", hits, misses)
+	}
+}
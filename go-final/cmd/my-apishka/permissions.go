@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"go-final/pkg/my-apishka/validator"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// showMyPermissionsHandler returns the roles of the authenticated user and the permission codes
+// they end up with.
+func (app *application) showMyPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	roles, err := app.models.Permissions.GetRolesForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listRolesHandler returns all roles and the permission codes each of them bundles.
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Permissions.GetRoles(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserIDParam reads the "id" URL parameter and checks that such a user exists. It sends the
+// error response itself and returns false if not.
+func (app *application) readUserIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return 0, false
+	}
+
+	_, err = app.models.Users.Get(r.Context(), int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return 0, false
+	}
+
+	return int64(id), true
+}
+
+// showUserPermissionsHandler returns the roles and the direct permissions of a user, along with
+// the permission codes the user ends up with.
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.readUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := app.models.Permissions.GetRolesForUser(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	direct, err := app.models.Permissions.GetDirectForUser(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	effective, err := app.models.Permissions.GetAllForUser(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	houses, err := app.models.Permissions.GetHousesForUser(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{
+		"roles":       roles,
+		"permissions": direct,
+		"effective":   effective,
+		"houses":      houses,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantRoleHandler grants a role to a user.
+func (app *application) grantRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.readUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Role != "", "role", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.AddRoleForUser(r.Context(), userID, input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			v.AddError("role", "no such role")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.permissionCache.invalidate(userID)
+	app.logGrant(r, "role granted", userID, input.Role)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role successfully granted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeRoleHandler revokes a role from a user.
+func (app *application) revokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.readUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	role := mux.Vars(r)["role"]
+
+	err := app.models.Permissions.RemoveRoleForUser(r.Context(), userID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.permissionCache.invalidate(userID)
+	app.logGrant(r, "role revoked", userID, role)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantPermissionsHandler grants permission codes to a user directly, on top of the user's roles.
+func (app *application) grantPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.readUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least one code")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate codes")
+	for _, code := range input.Permissions {
+		// Compare the codes exactly, a wildcard is only granted when it is asked for by name.
+		if !validator.In(code, known...) {
+			v.AddError("permissions", "unknown permission code "+strconv.Quote(code))
+			break
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(r.Context(), userID, input.Permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.permissionCache.invalidate(userID)
+	for _, code := range input.Permissions {
+		app.logGrant(r, "permission granted", userID, code)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "permissions successfully granted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokePermissionHandler revokes a permission code granted to a user directly. Permissions
+// which come from a role have to be revoked by revoking the role.
+func (app *application) revokePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.readUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	code := mux.Vars(r)["code"]
+
+	err := app.models.Permissions.RemoveForUser(r.Context(), userID, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.permissionCache.invalidate(userID)
+	app.logGrant(r, "permission revoked", userID, code)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "permission successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// assignHouseHandler assigns a house to a user, which lets an editor edit its characters.
+func (app *application) assignHouseHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.readUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		House string `json:"house"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.House != "", "house", "must be provided")
+	v.Check(len(input.House) <= 100, "house", "must not be more than 100 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.AddHouseForUser(r.Context(), userID, input.House)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logGrant(r, "house assigned", userID, input.House)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "house successfully assigned"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unassignHouseHandler removes a house assignment from a user.
+func (app *application) unassignHouseHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.readUserIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	house := mux.Vars(r)["house"]
+
+	err := app.models.Permissions.RemoveHouseForUser(r.Context(), userID, house)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logGrant(r, "house unassigned", userID, house)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "house successfully unassigned"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logGrant records who changed whose access, since these changes are worth auditing.
+func (app *application) logGrant(r *http.Request, message string, userID int64, grant string) {
+	app.logger.PrintInfo(message, traceProperties(r.Context(), map[string]string{
+		"user_id":  strconv.FormatInt(userID, 10),
+		"grant":    grant,
+		"admin_id": strconv.FormatInt(app.contextGetUser(r).ID, 10),
+	}))
+}
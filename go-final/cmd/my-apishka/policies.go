@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-final/pkg/policy"
+)
+
+// Actions checked against the resource-level policies.
+const (
+	actionUpdateCharacter = "character:update"
+	actionUpdateComment   = "comment:update"
+	actionDeleteComment   = "comment:delete"
+)
+
+// commentEditWindow is how long authors can edit their own comments.
+const commentEditWindow = 24 * time.Hour
+
+// newPolicyEngine returns the rules for actions on individual characters and comments.
+func newPolicyEngine() *policy.Engine {
+	return policy.New(
+		policy.Rule{
+			Name:      "comment-author",
+			Actions:   []string{actionUpdateComment},
+			Condition: policy.All(policy.Owner(), policy.CreatedWithin(commentEditWindow)),
+		},
+		policy.Rule{
+			Name:      "comment-moderator",
+			Actions:   []string{actionUpdateComment, actionDeleteComment},
+			Condition: policy.HasPermission("comments:write"),
+		},
+		policy.Rule{
+			Name:      "house-editor",
+			Actions:   []string{actionUpdateCharacter},
+			Condition: policy.All(policy.HasPermission("characters:write"), policy.AssignedHouse()),
+		},
+		policy.Rule{
+			Name:      "all-houses-editor",
+			Actions:   []string{actionUpdateCharacter},
+			Condition: policy.All(policy.HasPermission("characters:write"), policy.HasPermission("houses:all")),
+		},
+	)
+}
+
+// policySubject describes the request's user to the policy engine.
+func (app *application) policySubject(r *http.Request) (policy.Subject, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return policy.Subject{}, nil
+	}
+
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		return policy.Subject{}, err
+	}
+
+	houses, err := app.models.Permissions.GetHousesForUser(r.Context(), user.ID)
+	if err != nil {
+		return policy.Subject{}, err
+	}
+
+	return policy.Subject{
+		ID: user.ID,
+		Has: func(code string) bool {
+			return app.hasPermission(r, permissions, code)
+		},
+		Houses: houses,
+	}, nil
+}
+
+// authorize asks the policy engine whether the request's user may perform the action on the
+// resource, and logs the decision. If not, it sends the error response itself and returns false.
+func (app *application) authorize(w http.ResponseWriter, r *http.Request, action string, resource policy.Resource) bool {
+	subject, err := app.policySubject(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	decision := app.policies.Authorize(subject, action, resource)
+
+	app.logger.PrintInfo("authorization decision", traceProperties(r.Context(), map[string]string{
+		"action":   decision.Action,
+		"resource": resource.Kind + ":" + strconv.FormatInt(resource.ID, 10),
+		"user_id":  strconv.FormatInt(subject.ID, 10),
+		"allowed":  strconv.FormatBool(decision.Allowed),
+		"rule":     decision.Rule,
+	}))
+
+	if !decision.Allowed {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
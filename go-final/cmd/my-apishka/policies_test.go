@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// expectSubject sets up the queries policySubject makes for a user.
+func expectSubject(mock sqlmock.Sqlmock, permissions []string, houses []string) {
+	rows := sqlmock.NewRows([]string{"code"})
+	for _, code := range permissions {
+		rows.AddRow(code)
+	}
+	mock.ExpectQuery("SELECT permissions.code").WillReturnRows(rows)
+
+	rows = sqlmock.NewRows([]string{"house"})
+	for _, house := range houses {
+		rows.AddRow(house)
+	}
+	mock.ExpectQuery("FROM users_houses").WillReturnRows(rows)
+}
+
+// serveAs calls handler for the request as if the router had matched the given id and
+// authenticate had found user.
+func serveAs(app *application, handler http.HandlerFunc, method string, id int, body string, user *model.User) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(id)})
+	r = withUser(app, r, user)
+	rr := httptest.NewRecorder()
+
+	app.requireActivatedUser(handler)(rr, r)
+
+	return rr
+}
+
+func TestCommentPolicies(t *testing.T) {
+	const authorID = 1
+
+	author := &model.User{ID: authorID, Activated: true}
+	other := &model.User{ID: 2, Activated: true}
+	moderator := &model.User{ID: 3, Activated: true}
+
+	tests := []struct {
+		name        string
+		action      string
+		user        *model.User
+		permissions []string
+		createdAt   time.Time
+		wantStatus  int
+	}{
+		{"author updates", "update", author, nil, time.Now().Add(-time.Hour), http.StatusOK},
+		{"author updates after the edit window", "update", author, nil, time.Now().Add(-25 * time.Hour), http.StatusForbidden},
+		{"author deletes", "delete", author, nil, time.Now().Add(-time.Hour), http.StatusForbidden},
+		{"other user updates", "update", other, []string{"comments:read"}, time.Now().Add(-time.Hour), http.StatusForbidden},
+		{"other user deletes", "delete", other, []string{"comments:read"}, time.Now().Add(-time.Hour), http.StatusForbidden},
+		{"moderator updates", "update", moderator, []string{"comments:write"}, time.Now().Add(-48 * time.Hour), http.StatusOK},
+		{"moderator deletes", "delete", moderator, []string{"comments:*"}, time.Now().Add(-48 * time.Hour), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := newMockApplication(t)
+
+			mock.ExpectQuery("FROM comments").WithArgs(42).WillReturnRows(
+				sqlmock.NewRows([]string{"id", "usernameid", "comment", "characterid", "createdat"}).
+					AddRow(int64(42), int64(authorID), "Mischief managed", int64(5), tt.createdAt))
+			expectSubject(mock, tt.permissions, nil)
+
+			handler, method, body := app.UpdateCommentHandler, http.MethodPut, `{"Comment": "Edited"}`
+			if tt.action == "delete" {
+				handler, method, body = app.DeleteCommentHandler, http.MethodDelete, ""
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				if tt.action == "delete" {
+					mock.ExpectExec("DELETE FROM comments").WithArgs(42).WillReturnResult(sqlmock.NewResult(0, 1))
+				} else {
+					mock.ExpectExec("UPDATE comments").WithArgs("Edited", 42).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+			}
+
+			rr := serveAs(app, handler, method, 42, body, tt.user)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestCharacterPolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		houses      []string
+		wantStatus  int
+	}{
+		{"reader", []string{"characters:read"}, []string{"Gryffindor"}, http.StatusForbidden},
+		{"editor of the character's house", []string{"characters:write"}, []string{"Gryffindor"}, http.StatusOK},
+		{"editor of another house", []string{"characters:write"}, []string{"Slytherin"}, http.StatusForbidden},
+		{"editor without houses", []string{"characters:write"}, nil, http.StatusForbidden},
+		{"editor of all houses", []string{"characters:write", "houses:all"}, nil, http.StatusOK},
+		{"house assignment without write", []string{"houses:all"}, []string{"Gryffindor"}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := newMockApplication(t)
+
+			mock.ExpectQuery("FROM characters").WithArgs(9).WillReturnRows(
+				sqlmock.NewRows([]string{"id", "createdat", "updatedat", "firstname", "lastname", "house", "originstatus"}).
+					AddRow(int64(9), "2024-03-08", "2024-03-08", "Neville", "Longbottom", "Gryffindor", "Pure-blood"))
+			expectSubject(mock, tt.permissions, tt.houses)
+
+			if tt.wantStatus == http.StatusOK {
+				mock.ExpectQuery("UPDATE characters").WithArgs("Neville", "Longbottom-Abbott", "Gryffindor", 9).
+					WillReturnRows(sqlmock.NewRows([]string{"updatedat"}).AddRow("2024-03-09"))
+			}
+
+			rr := serveAs(app, app.updateCharacterHandler, http.MethodPatch, 9, `{"LastName": "Longbottom-Abbott"}`,
+				&model.User{ID: 4, Activated: true})
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateCommentAuthor(t *testing.T) {
+	app, mock, _ := newMockApplication(t)
+
+	// The author comes from the login, whatever the body claims.
+	mock.ExpectQuery("INSERT INTO comments").WithArgs(int64(2), "First!", int64(5)).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "createdat"}).AddRow(int64(1), time.Now()))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/comments",
+		strings.NewReader(`{"UsernameID": 1, "Comment": "First!", "CharacterID": 5}`))
+	r = withUser(app, r, &model.User{ID: 2, Activated: true})
+	rr := httptest.NewRecorder()
+
+	app.CreateCommentHandler(rr, r)
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+}
+
+func TestCreateCommentRequiresLogin(t *testing.T) {
+	app, _, _ := newMockApplication(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/comments",
+		strings.NewReader(`{"UsernameID": 1, "Comment": "First!", "CharacterID": 5}`))
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d; want %d: %s", rr.Code, http.StatusUnauthorized, rr.Body.String())
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/my-apishka/validator"
+
+	"gorm.io/gorm"
+)
+
+// emailChangeTTL is how long the confirmation token of an email change stays valid.
+const emailChangeTTL = 24 * time.Hour
+
+// currentUser loads the authenticated user from the database. The user in the request context
+// can't be used for updates: with JWT authentication it only carries the ID and activation
+// status.
+func (app *application) currentUser(w http.ResponseWriter, r *http.Request) *model.User {
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	if !app.checkExpectedVersion(w, r, user) {
+		return nil
+	}
+
+	return user
+}
+
+// checkCurrentPassword adds a validation error and returns false if the password isn't the
+// user's. Changes that could lock the owner out require it, so a stolen session alone isn't
+// enough to take the account over.
+func (app *application) checkCurrentPassword(v *validator.Validator, user *model.User, password string) (bool, error) {
+	match, err := user.Password.Matches(password)
+	if err != nil {
+		return false, err
+	}
+
+	if !match {
+		v.AddError("current_password", "is incorrect")
+	}
+
+	return match, nil
+}
+
+// showCurrentUserHandler returns the authenticated user's account.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	app.writeUserWithVersion(w, r, user, nil)
+}
+
+// updateCurrentUserHandler changes the authenticated user's username and profile. Only the
+// fields present in the request are changed.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Username       *string `json:"username"`
+		Bio            *string `json:"bio"`
+		AvatarURL      *string `json:"avatar_url"`
+		FavouriteHouse *string `json:"favourite_house"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Username != nil {
+		user.Username = *input.Username
+	}
+	if input.Bio != nil {
+		user.Bio = *input.Bio
+	}
+	if input.AvatarURL != nil {
+		user.AvatarURL = *input.AvatarURL
+	}
+	if input.FavouriteHouse != nil {
+		user.FavouriteHouse = *input.FavouriteHouse
+	}
+
+	v := validator.New()
+	model.ValidateUser(v, user)
+	model.ValidateProfile(v, user)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.updateUser(w, r, user) {
+		return
+	}
+
+	app.writeUserWithVersion(w, r, user, nil)
+}
+
+// changePasswordHandler sets a new password for the authenticated user. All sessions are
+// logged out, so the client has to log in again with the new password.
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	model.ValidatePasswordPlaintext(v, input.NewPassword)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if ok, err := app.checkCurrentPassword(v, user, input.CurrentPassword); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	} else if !ok {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.PasswordResetRequired = false
+
+	if !app.updateUser(w, r, user) {
+		return
+	}
+
+	err = app.revokeUserSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully changed, please log in again"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// requestEmailChangeHandler starts changing the authenticated user's email address. The address
+// only changes once the token in the response has been confirmed, which proves that the user
+// can receive mail there.
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Email           string `json:"email"`
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	model.ValidateEmail(v, input.Email)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if ok, err := app.checkCurrentPassword(v, user, input.CurrentPassword); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	} else if !ok {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(r.Context(), input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.PendingEmail = &input.Email
+
+	if !app.updateUser(w, r, user) {
+		return
+	}
+
+	// Only the newest request can be confirmed.
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), model.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, emailChangeTTL, model.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"email_change_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmEmailChangeHandler completes an email change with the token sent to the new address.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), model.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.PendingEmail == nil {
+		v.AddError("token", "invalid or expired email change token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.Email = *user.PendingEmail
+	user.PendingEmail = nil
+
+	if !app.updateUser(w, r, user) {
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), model.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCurrentUserHandler schedules the authenticated user's account for deletion and logs it
+// out everywhere. Logging in again before the grace period is over cancels the deletion.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.currentUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if ok, err := app.checkCurrentPassword(v, user, input.CurrentPassword); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	} else if !ok {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deletion := time.Now().Add(app.config.users.deletionGrace)
+	user.DeletionScheduledAt = &deletion
+
+	if !app.updateUser(w, r, user) {
+		return
+	}
+
+	err = app.revokeUserSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{
+		"message":               "your account will be deleted, log in again before then to keep it",
+		"deletion_scheduled_at": deletion,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
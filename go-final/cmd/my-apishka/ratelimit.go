@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-final/pkg/ratelimit"
+)
+
+// Route classes with their own per-client limits.
+const (
+	routeClassRead  = "read"
+	routeClassWrite = "write"
+	routeClassAuth  = "auth"
+)
+
+// authRoutes are the endpoints which check credentials or hand out tokens. They get the
+// strictest limit, since they are what password guessing scripts call.
+var authRoutes = map[string]bool{
+	"/api/v1/users":             true,
+	"/api/v1/users/login":       true,
+	"/api/v1/users/activated":   true,
+	"/api/v1/users/password":    true,
+	"/api/v1/users/email":       true,
+	"/api/v1/tokens/activation": true,
+	"/api/v1/tokens/refresh":    true,
+	"/api/v1/tokens/mfa":        true,
+	"/api/v1/oidc/login":        true,
+	"/api/v1/oidc/callback":     true,
+}
+
+// rateLimitCleanupInterval is how often idle buckets are dropped.
+const rateLimitCleanupInterval = time.Minute
+
+// routeClass returns the class of limit that applies to a request.
+func routeClass(r *http.Request) string {
+	switch {
+	case authRoutes[r.URL.Path]:
+		return routeClassAuth
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		return routeClassRead
+	default:
+		return routeClassWrite
+	}
+}
+
+// rateLimit applies the global limit and the per-IP limit. It runs before authentication, so
+// that a flood of requests doesn't reach the database.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !app.allow(w, r, "global", app.config.limiter.global) {
+			return
+		}
+
+		if !app.allow(w, r, "ip:"+app.clientIP(r), app.config.limiter.ip) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitClient applies the limit of the request's route class, per user for authenticated
+// requests and per IP otherwise. It has to run after authenticate.
+func (app *application) rateLimitClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		class := routeClass(r)
+
+		var limit ratelimit.Limit
+		switch class {
+		case routeClassAuth:
+			limit = app.config.limiter.auth
+		case routeClassRead:
+			limit = app.config.limiter.read
+		default:
+			limit = app.config.limiter.write
+		}
+
+		client := "ip:" + app.clientIP(r)
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			client = "user:" + strconv.FormatInt(user.ID, 10)
+		}
+
+		if !app.allow(w, r, class+":"+client, limit) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow counts the request against a limit and sets the RateLimit-* headers. If the limit is
+// exceeded, it sends a 429 response and returns false. When several limits apply, the headers
+// describe the one closest to running out.
+func (app *application) allow(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+	if limit.Unlimited() {
+		return true
+	}
+
+	res, err := app.limiter.Allow(key, limit)
+	if err != nil {
+		// Refusing every request because the limiter is broken would be worse than not
+		// limiting for a moment.
+		app.logger.PrintError(err, traceProperties(r.Context(), map[string]string{"rate_limit_key": key}))
+		return true
+	}
+
+	if remaining := w.Header().Get("RateLimit-Remaining"); remaining == "" || !res.Allowed || lessThan(res.Remaining, remaining) {
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	}
+
+	if !res.Allowed {
+		app.rateLimitExceededResponse(w, r, res.RetryAfter)
+		return false
+	}
+
+	return true
+}
+
+func lessThan(n int, header string) bool {
+	current, err := strconv.Atoi(header)
+	return err != nil || n < current
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// cleanupRateLimits drops the buckets of clients which have been quiet for a while, so the
+// limiter doesn't keep every IP address it has ever seen.
+func (app *application) cleanupRateLimits(memory *ratelimit.Memory) {
+	var idle time.Duration
+	for _, limit := range []ratelimit.Limit{
+		app.config.limiter.global, app.config.limiter.ip,
+		app.config.limiter.read, app.config.limiter.write, app.config.limiter.auth,
+	} {
+		if limit.Unlimited() {
+			continue
+		}
+		// After Burst/Rate a bucket has refilled and is the same as a new one.
+		if full := time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second)); full > idle {
+			idle = full
+		}
+	}
+
+	for range time.Tick(rateLimitCleanupInterval) {
+		memory.Cleanup(idle)
+	}
+}
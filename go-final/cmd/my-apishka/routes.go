@@ -25,7 +25,7 @@ func (app *application) routes() http.Handler {
 	// Обработчики маршрутов
 	v1.HandleFunc("/character", app.createCharacterHandler).Methods("POST")
 	v1.HandleFunc("/character/{id}", app.getCharacterHandler).Methods("GET")
-	v1.HandleFunc("/character/{id}", app.updateCharacterHandler).Methods("PUT")
+	v1.HandleFunc("/character/{id}", app.requireActivatedUser(app.updateCharacterHandler)).Methods("PUT")
 	//для специальных пользователей
 	v1.HandleFunc("/characters/{id}", app.requirePermissions("characters:write",app.deleteCharacterHandler)).Methods("DELETE")
 	// v1.HandleFunc("/character/{id}", app.deleteCharacterHandler).Methods("DELETE")
@@ -38,13 +38,71 @@ func (app *application) routes() http.Handler {
 	//для сущности юзера
 	v1.HandleFunc("/users",app.registerUserHandler).Methods("POST")
 	v1.HandleFunc("/users/activated", app.activateUserHandler).Methods("PUT")
+	v1.HandleFunc("/users/password", app.resetPasswordHandler).Methods("PUT")
 	v1.HandleFunc("/users/login", app.createAuthenticationTokenHandler).Methods("POST")
+	v1.HandleFunc("/tokens/activation", app.resendActivationTokenHandler).Methods("POST")
+	v1.HandleFunc("/tokens/refresh", app.refreshTokenHandler).Methods("POST")
+	v1.HandleFunc("/tokens/authentication", app.requireAuthenticatedUser(app.logoutHandler)).Methods("DELETE")
+	v1.HandleFunc("/tokens/mfa", app.createMFATokenHandler).Methods("POST")
+
+	//вход через внешнего OpenID Connect провайдера
+	v1.HandleFunc("/oidc/login", app.oidcLoginHandler).Methods("GET")
+	v1.HandleFunc("/oidc/callback", app.oidcCallbackHandler).Methods("GET")
+
+	//для администраторов
+	v1.HandleFunc("/admin/users", app.requirePermissions("users:admin", app.listUsersHandler)).Methods("GET")
+	v1.HandleFunc("/admin/users/{id}", app.requirePermissions("users:admin", app.showUserHandler)).Methods("GET")
+	v1.HandleFunc("/admin/users/{id}", app.requirePermissions("users:admin", app.deleteUserHandler)).Methods("DELETE")
+	v1.HandleFunc("/admin/users/{id}/suspension", app.requirePermissions("users:admin", app.suspendUserHandler)).Methods("PUT")
+	v1.HandleFunc("/admin/users/{id}/suspension", app.requirePermissions("users:admin", app.unsuspendUserHandler)).Methods("DELETE")
+	v1.HandleFunc("/admin/users/{id}/password-reset", app.requirePermissions("users:admin", app.forcePasswordResetHandler)).Methods("POST")
+	v1.HandleFunc("/admin/lockouts", app.requirePermissions("lockouts:write", app.unlockLoginHandler)).Methods("DELETE")
+	v1.HandleFunc("/admin/jobs", app.requirePermissions("jobs:read", app.listJobsHandler)).Methods("GET")
+	v1.HandleFunc("/admin/permissions/cache", app.requirePermissions("permissions:write", app.permissionCacheHandler)).Methods("GET")
+	v1.HandleFunc("/admin/roles", app.requirePermissions("permissions:write", app.listRolesHandler)).Methods("GET")
+	v1.HandleFunc("/admin/users/{id}/permissions", app.requirePermissions("permissions:write", app.showUserPermissionsHandler)).Methods("GET")
+	v1.HandleFunc("/admin/users/{id}/permissions", app.requirePermissions("permissions:write", app.grantPermissionsHandler)).Methods("POST")
+	v1.HandleFunc("/admin/users/{id}/permissions/{code}", app.requirePermissions("permissions:write", app.revokePermissionHandler)).Methods("DELETE")
+	v1.HandleFunc("/admin/users/{id}/houses", app.requirePermissions("permissions:write", app.assignHouseHandler)).Methods("POST")
+	v1.HandleFunc("/admin/users/{id}/houses/{house}", app.requirePermissions("permissions:write", app.unassignHouseHandler)).Methods("DELETE")
+	v1.HandleFunc("/admin/users/{id}/roles", app.requirePermissions("permissions:write", app.grantRoleHandler)).Methods("POST")
+	v1.HandleFunc("/admin/users/{id}/roles/{role}", app.requirePermissions("permissions:write", app.revokeRoleHandler)).Methods("DELETE")
+
+	//профиль текущего пользователя
+	v1.HandleFunc("/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler)).Methods("GET")
+	v1.HandleFunc("/users/me", app.requireActivatedUser(app.updateCurrentUserHandler)).Methods("PATCH")
+	v1.HandleFunc("/users/me", app.requireLoggedInUser(app.deleteCurrentUserHandler)).Methods("DELETE")
+	v1.HandleFunc("/users/me/password", app.requireLoggedInUser(app.changePasswordHandler)).Methods("PUT")
+	v1.HandleFunc("/users/me/email", app.requireLoggedInUser(app.requestEmailChangeHandler)).Methods("POST")
+	v1.HandleFunc("/users/email", app.confirmEmailChangeHandler).Methods("PUT")
+
+	//выгрузка персональных данных
+	v1.HandleFunc("/users/me/export", app.requireAuthenticatedUser(app.requestExportHandler)).Methods("POST")
+	v1.HandleFunc("/users/me/export", app.requireAuthenticatedUser(app.showExportHandler)).Methods("GET")
+	v1.HandleFunc("/exports/download", app.downloadExportHandler).Methods("GET")
+
+	//права текущего пользователя
+	v1.HandleFunc("/users/me/permissions", app.requireAuthenticatedUser(app.showMyPermissionsHandler)).Methods("GET")
+
+	//активные сессии текущего пользователя
+	v1.HandleFunc("/users/me/sessions", app.requireAuthenticatedUser(app.listSessionsHandler)).Methods("GET")
+	v1.HandleFunc("/users/me/sessions/{id}", app.requireAuthenticatedUser(app.deleteSessionHandler)).Methods("DELETE")
+
+	//API ключи для сервисов и скриптов
+	v1.HandleFunc("/users/me/api-keys", app.requireLoggedInUser(app.createAPIKeyHandler)).Methods("POST")
+	v1.HandleFunc("/users/me/api-keys", app.requireLoggedInUser(app.listAPIKeysHandler)).Methods("GET")
+	v1.HandleFunc("/users/me/api-keys/{id}", app.requireLoggedInUser(app.deleteAPIKeyHandler)).Methods("DELETE")
+
+	//двухфакторная аутентификация
+	v1.HandleFunc("/users/me/2fa", app.requireLoggedInUser(app.enrolTwoFactorHandler)).Methods("POST")
+	v1.HandleFunc("/users/me/2fa/confirm", app.requireLoggedInUser(app.confirmTwoFactorHandler)).Methods("POST")
+	v1.HandleFunc("/users/me/2fa", app.requireLoggedInUser(app.disableTwoFactorHandler)).Methods("DELETE")
 
 	//для сущности коммент
-	v1.HandleFunc("/comments", app.CreateCommentHandler).Methods("POST")
+	v1.HandleFunc("/comments", app.requireActivatedUser(app.CreateCommentHandler)).Methods("POST")
 	v1.HandleFunc("/comments/{id}", app.GetCommentHandler).Methods("GET")
-	v1.HandleFunc("/comments/{id}", app.UpdateCommentHandler).Methods("PUT")
-	v1.HandleFunc("/comments/{id}", app.requirePermissions("comments:write",app.DeleteCommentHandler)).Methods("DELETE")
+	v1.HandleFunc("/comments/{id}", app.requireActivatedUser(app.UpdateCommentHandler)).Methods("PUT")
+	v1.HandleFunc("/comments/{id}", app.requireActivatedUser(app.DeleteCommentHandler)).Methods("DELETE")
 	
 	//фильтрация,сортировка,пагинация для комментов
 	v1.HandleFunc("/commentsfilter", app.getCommentsByUserIDHandler).Methods("GET")              //фильтр по айди юзера указанного в парам   
@@ -57,5 +115,5 @@ func (app *application) routes() http.Handler {
 	//вывод списка комментариев по айди юзера
 	v1.HandleFunc("/users/{id}/comments", app.getUserCommentsHandler).Methods("GET")
 
-	return app.authenticate(r)
+	return app.collectMetrics(r, app.traceRequests(r, app.logRequests(r, app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.rateLimitClient(r))))))))
 }
\ No newline at end of file
@@ -23,6 +23,35 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// The admin server is kept off the public port, so that metrics and the log level are only
+	// reachable from inside the deployment.
+	var admin *http.Server
+	if app.config.admin.addr != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", app.metrics.registry.Handler())
+		adminMux.HandleFunc("/log/level", app.logLevelHandler)
+
+		admin = &http.Server{
+			Addr:         app.config.admin.addr,
+			Handler:      adminMux,
+			ErrorLog:     log.New(app.logger, "", 0),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			app.logger.PrintInfo("starting admin server", map[string]string{"addr": admin.Addr})
+
+			err := admin.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{"addr": admin.Addr})
+			}
+		}()
+	}
+
+	go app.toggleDebugOnHangup()
+
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
@@ -59,6 +88,27 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		if admin != nil {
+			if err := admin.Shutdown(ctx); err != nil {
+				app.logger.PrintError(err, map[string]string{"addr": admin.Addr})
+			}
+		}
+
+		// Let running maintenance jobs finish, but don't start new ones.
+		if app.scheduler != nil {
+			if err := app.scheduler.Shutdown(ctx); err != nil {
+				app.logger.PrintError(err, map[string]string{"component": "scheduler"})
+			}
+		}
+
+		// No requests are left to use the cached permissions or the denylist.
+		if app.permissionCache != nil {
+			app.permissionCache.stop()
+		}
+		if app.denylist != nil {
+			app.denylist.stop()
+		}
+
 		// Log a message to say that we're waiting for any background goroutines to complete
 		// their tasks.
 		app.logger.PrintInfo("completing background tasks", map[string]string{
@@ -69,6 +119,14 @@ func (app *application) serve() error {
 		// until the background goroutines have finished. Then we return nil on the shutdownError
 		// channel to indicate that the shutdown as compleeted without any issues.
 		app.wg.Wait()
+
+		// Send the spans of the last requests before exiting.
+		if app.shutdownTracing != nil {
+			if err := app.shutdownTracing(ctx); err != nil {
+				app.logger.PrintError(err, map[string]string{"component": "tracing"})
+			}
+		}
+
 		shutdownError <- nil
 
 	}()
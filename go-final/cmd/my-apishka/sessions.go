@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// sessionTouchInterval is the minimum time between two last-used updates of the same token.
+// Without it every authenticated request would cost an extra write to the tokens table.
+const sessionTouchInterval = time.Minute
+
+// sessionTracker remembers when each authentication token was last written back to the
+// database, so that the authenticate middleware only touches a token once per interval.
+type sessionTracker struct {
+	mu       sync.Mutex
+	interval time.Duration
+	touched  map[[sha256.Size]byte]time.Time
+}
+
+func newSessionTracker(interval time.Duration) *sessionTracker {
+	return &sessionTracker{
+		interval: interval,
+		touched:  make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// due reports whether the token should be touched now, and if so records that it has been.
+func (t *sessionTracker) due(tokenPlaintext string) bool {
+	key := sha256.Sum256([]byte(tokenPlaintext))
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.touched[key]; ok && now.Sub(last) < t.interval {
+		return false
+	}
+
+	// Drop entries which are past their interval anyway, so the map doesn't grow with every
+	// token ever seen by this process.
+	if len(t.touched) > 10_000 {
+		for k, last := range t.touched {
+			if now.Sub(last) >= t.interval {
+				delete(t.touched, k)
+			}
+		}
+	}
+
+	t.touched[key] = now
+	return true
+}
+
+// listSessionsHandler returns the active sessions of the authenticated user.
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSessionHandler revokes a single session of the authenticated user, leaving the others
+// logged in.
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestResponse(w, r, errors.New("invalid id parameter"))
+		return
+	}
+
+	err = app.models.Tokens.DeleteSessionForUser(r.Context(), id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-final/pkg/jsonlog"
+	"go-final/pkg/jwt"
+	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/totp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newTestApplication returns an application backed by db, whose log entries are written to the
+// returned buffer.
+func newTestApplication(t *testing.T, db *sql.DB) (*application, *bytes.Buffer) {
+	t.Helper()
+
+	var logs bytes.Buffer
+
+	app := &application{
+		models:   model.NewModels(db, model.Timeouts{Query: time.Minute, Batch: time.Minute}),
+		logger:   jsonlog.NewLogger(&logs, jsonlog.LevelInfo),
+		sessions: newSessionTracker(sessionTouchInterval),
+		denylist: newDenylist(),
+		totp:     totp.New(nil),
+		policies: newPolicyEngine(),
+		metrics:  newMetrics(db),
+	}
+	app.permissionCache = newPermissionCache(0, app.models.Permissions.GetAllForUser)
+
+	return app, &logs
+}
+
+// newMockApplication returns an application backed by a mock database, on which the test sets
+// up the queries it expects.
+func newMockApplication(t *testing.T) (*application, sqlmock.Sqlmock, *bytes.Buffer) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	app, logs := newTestApplication(t, db)
+	return app, mock, logs
+}
+
+// withUser returns r as authenticate would pass it on for user.
+func withUser(app *application, r *http.Request, user *model.User) *http.Request {
+	return app.contextSetUser(r, user)
+}
+
+// blockingConnector opens connections whose queries never finish on their own. Like lib/pq,
+// they give up with the context's error once it is done.
+type blockingConnector struct{}
+
+func (blockingConnector) Connect(context.Context) (driver.Conn, error) { return blockingConn{}, nil }
+func (blockingConnector) Driver() driver.Driver                        { return blockingDriver{} }
+
+type blockingDriver struct{}
+
+func (blockingDriver) Open(string) (driver.Conn, error) { return blockingConn{}, nil }
+
+type blockingConn struct{}
+
+func (blockingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (blockingConn) Close() error                        { return nil }
+func (blockingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (blockingConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingConn) ExecContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// userColumns are the column names of the rows returned for the model's userColumns.
+var userColumns = []string{"id", "createdat", "username", "email", "password", "activated", "version",
+	"suspendedat", "passwordresetrequired", "bio", "avatarurl", "favouritehouse", "pendingemail",
+	"deletionscheduledat"}
+
+// userValues returns the values of userColumns for user. The password hash is one which no
+// password matches.
+func userValues(user *model.User) []driver.Value {
+	var suspendedAt, pendingEmail, deletionScheduledAt driver.Value
+	if user.SuspendedAt != nil {
+		suspendedAt = *user.SuspendedAt
+	}
+	if user.PendingEmail != nil {
+		pendingEmail = *user.PendingEmail
+	}
+	if user.DeletionScheduledAt != nil {
+		deletionScheduledAt = *user.DeletionScheduledAt
+	}
+
+	return []driver.Value{user.ID, user.CreatedAt, user.Username, user.Email, []byte("-"), user.Activated,
+		int64(user.Version), suspendedAt, user.PasswordResetRequired, user.Bio, user.AvatarURL,
+		user.FavouriteHouse, pendingEmail, deletionScheduledAt}
+}
+
+// withJWT switches app to JWT access tokens signed with a test key.
+func withJWT(t *testing.T, app *application) {
+	t.Helper()
+
+	key, err := jwt.NewHMACKey("test", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.jwt, err = jwt.NewKeySet("test", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signJWT returns an access token for an activated user, valid for an hour.
+func signJWT(t *testing.T, app *application, id string, userID int64) string {
+	t.Helper()
+
+	now := time.Now()
+	token, err := app.jwt.Sign(jwt.Claims{
+		Subject:   strconv.FormatInt(userID, 10),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		ID:        id,
+		Session:   "0a0b",
+		Activated: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
-	"time"
+	"strconv"
 
 	"go-final/pkg/my-apishka/model"
 	"go-final/pkg/my-apishka/validator"
@@ -36,13 +36,19 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Refuse to even look at the password while the account or the client is locked out.
+	if !app.checkLoginAllowed(w, r, input.Email) {
+		return
+	}
+
 	// Lookup the user record based on the email address. If no matching user was found, then we
 	// call the app.invalidCredentialsResponse() helper to send a 501 Unauthorized response to
 	// the client.
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.recordLoginFailure(r, input.Email)
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -60,20 +66,141 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// If the passwords don't match, then call the app.invalidCredentialsResponse() helper
 	// and return
 	if !match {
+		app.recordLoginFailure(r, input.Email)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour expiry time
-	// and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, model.ScopeAuthentication)
+	// An admin has forced a password reset, so the current password no longer works.
+	if user.PasswordResetRequired {
+		app.passwordResetRequiredResponse(w, r)
+		return
+	}
+
+	app.completeLogin(w, r, user, true)
+}
+
+// completeLogin finishes a login once the user's first factor has been checked. If the user has
+// two-factor authentication enabled, that is not enough: it hands out a short-lived mfa-pending
+// token which can be exchanged at /tokens/mfa together with a valid code. Otherwise it starts a
+// new session for the user, and clears the failed logins of the account if resetLockout is set.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *model.User, resetLockout bool) {
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	// Logging in during the grace period keeps the account.
+	if user.DeletionScheduledAt != nil {
+		user.DeletionScheduledAt = nil
+
+		if !app.updateUser(w, r, user) {
+			return
+		}
+
+		app.logger.PrintInfo("account deletion cancelled", traceProperties(r.Context(), map[string]string{
+			"user_id": strconv.FormatInt(user.ID, 10),
+		}))
+	}
+
+	enabled, err := app.models.TwoFactor.Enabled(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enabled {
+		token, err := app.models.Tokens.New(r.Context(), user.ID, mfaPendingTTL, model.ScopeMFAPending)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// The failures of the account are only cleared once the code has been checked too, in
+		// createMFATokenHandler. Otherwise someone who has the password could log in again after
+		// every few wrong codes and guess on without ever being locked out.
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"mfa_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The password was right, so start counting from zero again.
+	if resetLockout {
+		app.recordLoginSuccess(r, user.Email)
+	}
+
+	app.issueTokenPair(w, r, user.ID, nil)
+}
+
+// refreshTokenHandler exchanges a refresh token for a new access/refresh pair. Each refresh
+// token can be used once; presenting it a second time revokes the whole session.
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.ConsumeRefresh(r.Context(), input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrTokenReused):
+			app.logger.PrintInfo("refresh token reused, session revoked", traceProperties(r.Context(), map[string]string{
+				"request_url": loggedURL(r),
+				"ip":          app.clientIP(r),
+			}))
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.issueTokenPair(w, r, token.UserID, token.Family)
+}
+
+// issueTokenPair creates an access token and a refresh token for the user and sends them to the
+// client with a 201 Created status code. A nil family starts a new session.
+func (app *application) issueTokenPair(w http.ResponseWriter, r *http.Request, userID int64, family []byte) {
+	var access, refresh *model.Token
+	var err error
+
+	if app.jwt != nil {
+		// In JWT mode only the refresh token is stored; the access token is signed instead.
+		refresh, err = app.models.Tokens.NewRefresh(r.Context(), userID, app.config.tokens.refreshTTL, family,
+			r.UserAgent(), app.clientIP(r))
+		if err == nil {
+			access, err = app.newAccessJWT(r.Context(), userID, refresh.Family)
+		}
+	} else {
+		access, refresh, err = app.models.Tokens.NewPair(r.Context(), userID, app.config.tokens.accessTTL,
+			app.config.tokens.refreshTTL, family, r.UserAgent(), app.clientIP(r))
+	}
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Encode the token to JSON and send it in the response along with a 201 Created status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	// Encode the tokens to JSON and send them in the response along with a 201 Created status code.
+	err = app.writeJSON(w, http.StatusCreated, envelope{
+		"authentication_token": access,
+		"refresh_token":        refresh,
+	}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
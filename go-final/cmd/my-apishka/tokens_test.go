@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-final/pkg/lockout"
+	"go-final/pkg/my-apishka/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRefreshTokenReuse(t *testing.T) {
+	app, mock, logs := newMockApplication(t)
+	family := []byte("family-of-tokens")
+	refresh := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/tokens/refresh",
+			strings.NewReader(`{"refresh_token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"}`))
+		rr := httptest.NewRecorder()
+		app.refreshTokenHandler(rr, r)
+		return rr
+	}
+
+	// The first exchange issues a new pair in the same family.
+	mock.ExpectQuery("UPDATE tokens").WillReturnRows(
+		sqlmock.NewRows([]string{"user_id", "expiry", "family", "user_agent", "ip"}).
+			AddRow(int64(1), time.Now().Add(time.Hour), family, "curl", "192.0.2.1"))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO tokens").WithArgs(sqlmock.AnyArg(), int64(1), sqlmock.AnyArg(), "authentication",
+		sqlmock.AnyArg(), sqlmock.AnyArg(), family).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO tokens").WithArgs(sqlmock.AnyArg(), int64(1), sqlmock.AnyArg(), "refresh",
+		sqlmock.AnyArg(), sqlmock.AnyArg(), family).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if rr := refresh(); rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d on first use; want %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+
+	// The token is now used, so presenting it again revokes the family.
+	mock.ExpectQuery("UPDATE tokens").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "expiry", "family", "user_agent", "ip"}))
+	mock.ExpectQuery("used_at IS NOT NULL").WillReturnRows(sqlmock.NewRows([]string{"family"}).AddRow(family))
+	mock.ExpectExec("DELETE FROM tokens").WithArgs(family).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	if rr := refresh(); rr.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d on second use; want %d: %s", rr.Code, http.StatusUnauthorized, rr.Body.String())
+	}
+	if !strings.Contains(logs.String(), "refresh token reused") {
+		t.Errorf("got logs %q; want the reuse logged", logs.String())
+	}
+}
+
+func TestPasswordLoginLockoutReset(t *testing.T) {
+	tests := []struct {
+		name         string
+		twoFactor    bool
+		wantStatus   int
+		wantFailures int
+	}{
+		{"without 2FA", false, http.StatusCreated, 0},
+		// The password alone doesn't clear the failures, or wrong codes could be guessed forever.
+		{"with 2FA", true, http.StatusAccepted, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := newMockApplication(t)
+			store := lockout.NewMemoryStore()
+			app.lockout = lockout.New(store)
+			app.config.lockout.threshold = 5
+			app.config.lockout.ipThreshold = 20
+			app.config.lockout.base = time.Minute
+			app.config.lockout.max = time.Hour
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/tokens/authentication",
+				strings.NewReader(`{"Email": "hermione@example.com", "Password": "pa55word1234"}`))
+			accountKey, _ := app.loginKeys(r, "hermione@example.com")
+
+			for i := 0; i < 4; i++ {
+				if _, _, err := app.lockout.Fail(context.Background(), accountKey, app.accountPolicy()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			hash, err := bcrypt.GenerateFromPassword([]byte("pa55word1234"), bcrypt.MinCost)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := userValues(&model.User{ID: 3, CreatedAt: time.Now(), Username: "hermione",
+				Email: "hermione@example.com", Activated: true, Version: 1})
+			values[4] = hash
+			mock.ExpectQuery("WHERE Email").WillReturnRows(sqlmock.NewRows(userColumns).AddRow(values...))
+
+			totpRows := sqlmock.NewRows([]string{"user_id", "secret", "confirmed", "last_step"})
+			if tt.twoFactor {
+				totpRows.AddRow(int64(3), "JBSWY3DPEHPK3PXP", true, int64(0))
+			}
+			mock.ExpectQuery("FROM users_totp").WillReturnRows(totpRows)
+
+			if tt.twoFactor {
+				mock.ExpectExec("INSERT INTO tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+			} else {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			rr := httptest.NewRecorder()
+			app.createAuthenticationTokenHandler(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+
+			attempts, err := store.Get(context.Background(), accountKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attempts.Failures != tt.wantFailures {
+				t.Errorf("got %d failures; want %d", attempts.Failures, tt.wantFailures)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go-final/pkg/my-apishka/model"
+	"go-final/pkg/my-apishka/validator"
+	"go-final/pkg/totp"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// totpIssuer is shown next to the account in authenticator apps.
+	totpIssuer = "Harry Potter API"
+
+	// mfaPendingTTL is how long a user has to enter their code after a correct password.
+	mfaPendingTTL = 5 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+// enrolTwoFactorHandler starts TOTP enrolment for the authenticated user. It returns the secret
+// and the otpauth:// URI to add to an authenticator app. Two-factor authentication is only
+// enabled once a code has been confirmed.
+func (app *application) enrolTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	// Load the full user record; in JWT mode the context only holds the user's ID.
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TwoFactor.Enrol(r.Context(), user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrTwoFactorEnabled):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"two_factor": map[string]string{
+		"secret": secret,
+		"uri":    app.totp.URI(totpIssuer, user.Email, secret),
+	}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTwoFactorHandler enables two-factor authentication once the user proves their app
+// produces valid codes, and returns a fresh set of single-use recovery codes.
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tf, err := app.models.TwoFactor.Get(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication has not been started")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if tf.Confirmed {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	ok, err := app.checkTOTP(r.Context(), tf, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		v := validator.New()
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TwoFactor.Confirm(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	codes, err := app.models.TwoFactor.NewRecoveryCodes(r.Context(), user.ID, recoveryCodeCount)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTwoFactorHandler turns two-factor authentication off. It requires a current code so
+// that a stolen session alone can't remove the second factor.
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tf, err := app.models.TwoFactor.Get(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := app.checkTOTP(r.Context(), tf, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		v := validator.New()
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TwoFactor.Disable(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFATokenHandler exchanges an mfa-pending token and a TOTP or recovery code for an
+// authentication token.
+func (app *application) createMFATokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	model.ValidateTokenPlaintext(v, input.MFAToken)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), model.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkLoginAllowed(w, r, user.Email) {
+		return
+	}
+
+	var ok bool
+	if input.Code != "" {
+		var tf *model.TwoFactor
+		tf, err = app.models.TwoFactor.Get(r.Context(), user.ID)
+		if err == nil {
+			ok, err = app.checkTOTP(r.Context(), tf, input.Code)
+		}
+	} else {
+		ok, err = app.models.TwoFactor.UseRecoveryCode(r.Context(), user.ID, input.RecoveryCode)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.recordLoginFailure(r, user.Email)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	app.recordLoginSuccess(r, user.Email)
+
+	// The pending token has done its job; don't let it be exchanged a second time.
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), model.ScopeMFAPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.issueTokenPair(w, r, user.ID, nil)
+}
+
+// checkTOTP validates a code against the user's secret and records the time step it was
+// generated for, so that the same code can't be used again.
+func (app *application) checkTOTP(ctx context.Context, tf *model.TwoFactor, code string) (bool, error) {
+	step, ok := app.totp.Validate(tf.Secret, code, tf.LastStep)
+	if !ok {
+		return false, nil
+	}
+
+	return app.models.TwoFactor.UseStep(ctx, tf.UserID, step)
+}
@@ -2,10 +2,13 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,69 +24,242 @@ const (
 	LevelOff                // Has the value of 3.
 )
 
+// LevelDebug and LevelWarn were added later, with values which leave those of the levels above
+// unchanged. Numerically LevelWarn is below LevelInfo, so levels must be compared with Less
+// rather than <.
+const (
+	LevelDebug Level = -2
+	LevelWarn  Level = -1
+)
+
+// levels lists the levels from the least to the most severe.
+var levels = []Level{LevelDebug, LevelInfo, LevelWarn, LevelError, LevelFatal, LevelOff}
+
+// severity returns the position of the level in levels.
+func (l Level) severity() int {
+	for i, level := range levels {
+		if l == level {
+			return i
+		}
+	}
+	return len(levels)
+}
+
+// Less reports whether l is less severe than other, such as LevelInfo than LevelWarn.
+func (l Level) Less(other Level) bool {
+	return l.severity() < other.severity()
+}
+
 // String returns a human-friendly string for the severity level.
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
+// ParseLevel returns the level with the given name, such as "debug" or "WARN".
+func ParseLevel(s string) (Level, error) {
+	for _, l := range levels {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+
+	return LevelOff, fmt.Errorf("jsonlog: unknown level %q", s)
+}
+
 // Logger is the custom logger. It holds the output destination that the log entries will be
 // written to, the minimum severity level that log entries will be written for, and a mutex
-// for coordination the writes.
+// for coordination the writes. Loggers made by With share all of these with their parent, and
+// add their bound fields to every entry.
 type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	core   *core
+	fields []interface{}
+}
+
+// core is the part of a Logger shared with its children. The levels can be changed while the
+// logger is in use, so they are kept as atomics.
+type core struct {
+	out        io.Writer
+	mu         sync.Mutex
+	minLevel   atomic.Int32
+	stackLevel atomic.Int32
 }
 
 // NewLogger returns a new Logger instance which writes log entries at or above a minimum severity
-// level to a specific output destination.
+// level to a specific output destination. Entries at the ERROR level and above include a stack
+// trace, see SetStackTraceLevel.
 func NewLogger(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
-	}
+	c := &core{out: out}
+	c.minLevel.Store(int32(minLevel))
+	c.stackLevel.Store(int32(LevelError))
+
+	return &Logger{core: c}
+}
+
+// SetLevel changes the minimum severity level of the logger and all loggers sharing its output.
+// It is safe to call while the logger is in use.
+func (l *Logger) SetLevel(level Level) {
+	l.core.minLevel.Store(int32(level))
+}
+
+// Level returns the current minimum severity level.
+func (l *Logger) Level() Level {
+	return Level(l.core.minLevel.Load())
+}
+
+// SetStackTraceLevel sets the severity level from which entries include a stack trace. LevelOff
+// leaves stack traces out altogether.
+func (l *Logger) SetStackTraceLevel(level Level) {
+	l.core.stackLevel.Store(int32(level))
+}
+
+// With returns a child logger which adds the given key/value pairs to every entry, such as
+// logger.With("request_id", id). The child writes to the same output and follows level changes
+// of its parent.
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyValues...)
+
+	return &Logger{core: l.core, fields: fields}
+}
+
+// Debug writes a Debug level entry. keyValues are alternating keys and values, where the values
+// can be of any type: numbers, booleans, durations, errors, and maps or structs which are written
+// as nested objects.
+func (l *Logger) Debug(message string, keyValues ...interface{}) {
+	l.print(LevelDebug, message, l.properties(nil, keyValues))
+}
+
+// Info writes an Info level entry. See Debug for keyValues.
+func (l *Logger) Info(message string, keyValues ...interface{}) {
+	l.print(LevelInfo, message, l.properties(nil, keyValues))
+}
+
+// Warn writes a Warn level entry. See Debug for keyValues.
+func (l *Logger) Warn(message string, keyValues ...interface{}) {
+	l.print(LevelWarn, message, l.properties(nil, keyValues))
+}
+
+// Error writes an Error level entry. See Debug for keyValues.
+func (l *Logger) Error(message string, keyValues ...interface{}) {
+	l.print(LevelError, message, l.properties(nil, keyValues))
+}
+
+// PrintDebug is a helper that writes Debug level log entries.
+func (l *Logger) PrintDebug(message string, properties map[string]string) {
+	l.print(LevelDebug, message, l.properties(properties, nil))
 }
 
 // PrintInfo is a helper that writes Info level log entries.
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+	l.print(LevelInfo, message, l.properties(properties, nil))
 }
 
-// PrintError is a helper that writes Info level log entries.
+// PrintWarn is a helper that writes Warn level log entries.
+func (l *Logger) PrintWarn(message string, properties map[string]string) {
+	l.print(LevelWarn, message, l.properties(properties, nil))
+}
+
+// PrintError is a helper that writes Error level log entries.
 func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+	l.print(LevelError, err.Error(), l.properties(properties, nil))
 }
 
-// PrintFatal is a helper that writes Info level log entries. It also terminates the application.
+// PrintFatal is a helper that writes Fatal level log entries. It also terminates the application,
+// after flushing the output. The entry is written past any queue, see SyncWriter, so that it
+// isn't lost.
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+	l.print(LevelFatal, err.Error(), l.properties(properties, nil))
+	l.Flush()
 	os.Exit(1)
 }
 
+// Flush writes out the entries still buffered by the output, if it is a Flusher such as Async
+// or RotatingFile. It should be called before the application exits.
+func (l *Logger) Flush() error {
+	if f, ok := l.core.out.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// properties merges the bound fields of the logger, string properties of the Print helpers and
+// key/value pairs into the properties of an entry. Later keys win over earlier ones.
+func (l *Logger) properties(strs map[string]string, keyValues []interface{}) map[string]interface{} {
+	if len(l.fields) == 0 && len(strs) == 0 && len(keyValues) == 0 {
+		return nil
+	}
+
+	properties := make(map[string]interface{}, len(l.fields)/2+len(strs)+len(keyValues)/2)
+	addKeyValues(properties, l.fields)
+	for k, v := range strs {
+		properties[k] = v
+	}
+	addKeyValues(properties, keyValues)
+
+	return properties
+}
+
+// addKeyValues adds alternating keys and values to properties. A value without a key is stored
+// under "!BADKEY", so that it isn't lost.
+func addKeyValues(properties map[string]interface{}, keyValues []interface{}) {
+	for i := 0; i < len(keyValues); i += 2 {
+		if i+1 == len(keyValues) {
+			properties["!BADKEY"] = fieldValue(keyValues[i])
+			return
+		}
+
+		key, ok := keyValues[i].(string)
+		if !ok {
+			key = fmt.Sprint(keyValues[i])
+		}
+		properties[key] = fieldValue(keyValues[i+1])
+	}
+}
+
+// fieldValue converts the values which encoding/json doesn't write usefully. Durations would
+// otherwise be written as nanoseconds and errors as empty objects.
+func fieldValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.String()
+	case error:
+		return v.Error()
+	default:
+		return v
+	}
+}
+
 // print is an internal method for writing a log entry.
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+func (l *Logger) print(level Level, message string, properties map[string]interface{}) (int, error) {
 	// If the severity level of the log entry is below the minimum severity for the logger
 	// then return with no further action
-	if level < l.minLevel {
+	if level.Less(l.Level()) {
 		return 0, nil
 	}
 
 	// Declare an anonymous struct holding the data for the log entry.
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string                 `json:"level"`
+		Time       string                 `json:"time"`
+		Message    string                 `json:"message"`
+		Properties map[string]interface{} `json:"properties,omitempty"`
+		Trace      string                 `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
 		Time:       time.Now().UTC().Format(time.RFC3339),
@@ -91,8 +267,8 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		Properties: properties,
 	}
 
-	// Include a stack trace for entries at the ERROR and FATAL levels.
-	if level >= LevelError {
+	// Include a stack trace for entries at or above the stack trace level.
+	if !level.Less(Level(l.core.stackLevel.Load())) {
 		aux.Trace = string(debug.Stack())
 	}
 
@@ -110,15 +286,19 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	// Lock the mutex so that no two writes to the output destination cannot happen concurrently.
 	// If we don't do this, it's possible that the text for two or more log entries will
 	// be intermingled in the output
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
 
-	// Write the log entry followed by a newline.
-	return l.out.Write(append(line, '\n'))
+	// Write the log entry followed by a newline. A FATAL entry bypasses any queue, since the
+	// application exits straight after it.
+	if sw, ok := l.core.out.(SyncWriter); ok && level == LevelFatal {
+		return sw.WriteSync(append(line, '\n'))
+	}
+	return l.core.out.Write(append(line, '\n'))
 }
 
 // Write satisfies the io.Writer interface. It writes a log entry at the ERROR level with
 // no additional properties
 func (l *Logger) Write(message []byte) (n int, err error) {
-	return l.print(LevelError, string(message), nil)
-}
\ No newline at end of file
+	return l.print(LevelError, string(message), l.properties(nil, nil))
+}
@@ -0,0 +1,276 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// entry is a log entry as written by Logger.
+type entry struct {
+	Level      string                 `json:"level"`
+	Time       string                 `json:"time"`
+	Message    string                 `json:"message"`
+	Properties map[string]interface{} `json:"properties"`
+	Trace      string                 `json:"trace"`
+}
+
+// entries decodes the entries written to buf.
+func entries(t *testing.T, buf *bytes.Buffer) []entry {
+	t.Helper()
+
+	var got []entry
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var e entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("decoding %q: %v", line, err)
+		}
+		got = append(got, e)
+	}
+
+	return got
+}
+
+func TestLevelValues(t *testing.T) {
+	// The levels which existed before DEBUG and WARN keep their values.
+	for level, want := range map[Level]int8{LevelInfo: 0, LevelError: 1, LevelFatal: 2, LevelOff: 3} {
+		if int8(level) != want {
+			t.Errorf("%s has the value %d; want %d", level, level, want)
+		}
+	}
+
+	for i := 1; i < len(levels); i++ {
+		if !levels[i-1].Less(levels[i]) || levels[i].Less(levels[i-1]) {
+			t.Errorf("got %s and %s out of order", levels[i-1], levels[i])
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Level
+	}{
+		{"debug", LevelDebug},
+		{"INFO", LevelInfo},
+		{"Warn", LevelWarn},
+		{"error", LevelError},
+		{"fatal", LevelFatal},
+		{"off", LevelOff},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.s)
+		if err != nil {
+			t.Errorf("ParseLevel(%q): %v", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLevel(%q) = %s; want %s", tt.s, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "warning", "trace", "1"} {
+		if _, err := ParseLevel(s); err == nil {
+			t.Errorf("ParseLevel(%q) succeeded; want an error", s)
+		}
+	}
+}
+
+func TestMinimumLevel(t *testing.T) {
+	tests := []struct {
+		min  Level
+		want []string
+	}{
+		{LevelDebug, []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{LevelInfo, []string{"INFO", "WARN", "ERROR"}},
+		{LevelWarn, []string{"WARN", "ERROR"}},
+		{LevelError, []string{"ERROR"}},
+		{LevelOff, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.min.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := NewLogger(&buf, tt.min)
+
+			logger.Debug("debug")
+			logger.PrintInfo("info", nil)
+			logger.Warn("warn")
+			logger.PrintError(errors.New("error"), nil)
+
+			var got []string
+			for _, e := range entries(t, &buf) {
+				got = append(got, e.Level)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got levels %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelInfo)
+	child := logger.With("component", "scheduler")
+
+	child.Debug("hidden")
+	logger.SetLevel(LevelDebug)
+	child.Debug("shown")
+
+	if got := child.Level(); got != LevelDebug {
+		t.Errorf("got child level %s; want %s", got, LevelDebug)
+	}
+
+	got := entries(t, &buf)
+	if len(got) != 1 || got[0].Message != "shown" {
+		t.Errorf("got entries %+v; want only the one logged after SetLevel", got)
+	}
+
+	// Changing the level while entries are written is safe.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logger.SetLevel(levels[(i+j)%len(levels)])
+				child.Info("busy")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestWith(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelInfo)
+
+	request := logger.With("request_id", "abc", "user_id", 7)
+	job := request.With("user_id", 8)
+
+	request.Info("request", "status", 200)
+	job.Info("job", "status", 500)
+	logger.Info("plain")
+	request.PrintInfo("helper", map[string]string{"request_id": "override"})
+
+	got := entries(t, &buf)
+	if len(got) != 4 {
+		t.Fatalf("got %d entries; want 4", len(got))
+	}
+
+	want := []map[string]interface{}{
+		{"request_id": "abc", "user_id": float64(7), "status": float64(200)},
+		// A child's fields win over its parent's, and the parent is unchanged.
+		{"request_id": "abc", "user_id": float64(8), "status": float64(500)},
+		nil,
+		// So do the properties of an entry.
+		{"request_id": "override", "user_id": float64(7)},
+	}
+
+	for i, e := range got {
+		if len(e.Properties) != len(want[i]) {
+			t.Errorf("entry %d: got properties %v; want %v", i, e.Properties, want[i])
+			continue
+		}
+		for k, v := range want[i] {
+			if e.Properties[k] != v {
+				t.Errorf("entry %d: got %s = %v; want %v", i, k, e.Properties[k], v)
+			}
+		}
+	}
+}
+
+func TestFieldEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelInfo)
+
+	logger.Info("fields",
+		"duration", 1500*time.Millisecond,
+		"err", errors.New("connection refused"),
+		"count", 3,
+		"ratio", 0.5,
+		"ok", true,
+		"nested", map[string]int{"hits": 2},
+		"point", struct {
+			X int `json:"x"`
+		}{X: 1},
+		42, "non-string key",
+		"dangling",
+	)
+
+	props := entries(t, &buf)[0].Properties
+
+	want := map[string]interface{}{
+		"duration": "1.5s",
+		"err":      "connection refused",
+		"count":    float64(3),
+		"ratio":    0.5,
+		"ok":       true,
+		"42":       "non-string key",
+		"!BADKEY":  "dangling",
+	}
+	for k, v := range want {
+		if props[k] != v {
+			t.Errorf("got %s = %#v; want %#v", k, props[k], v)
+		}
+	}
+
+	if nested, ok := props["nested"].(map[string]interface{}); !ok || nested["hits"] != float64(2) {
+		t.Errorf("got nested = %#v; want an object with hits 2", props["nested"])
+	}
+	if point, ok := props["point"].(map[string]interface{}); !ok || point["x"] != float64(1) {
+		t.Errorf("got point = %#v; want an object with x 1", props["point"])
+	}
+}
+
+func TestStackTraceLevel(t *testing.T) {
+	tests := []struct {
+		stackLevel Level
+		wantTrace  []bool // For a WARN and an ERROR entry.
+	}{
+		{LevelError, []bool{false, true}},
+		{LevelWarn, []bool{true, true}},
+		{LevelOff, []bool{false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.stackLevel.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := NewLogger(&buf, LevelInfo)
+			logger.SetStackTraceLevel(tt.stackLevel)
+
+			logger.Warn("warn")
+			logger.Error("error")
+
+			for i, e := range entries(t, &buf) {
+				if got := e.Trace != ""; got != tt.wantTrace[i] {
+					t.Errorf("%s entry: got a trace %t; want %t", e.Level, got, tt.wantTrace[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultStackTraceLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelInfo)
+
+	logger.PrintInfo("info", nil)
+	logger.PrintError(errors.New("error"), nil)
+
+	got := entries(t, &buf)
+	if got[0].Trace != "" || !strings.Contains(got[1].Trace, "jsonlog_test.go") {
+		t.Errorf("got traces %q and %q; want only the ERROR entry to have one", got[0].Trace, got[1].Trace)
+	}
+}
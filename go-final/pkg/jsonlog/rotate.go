@@ -0,0 +1,257 @@
+package jsonlog
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files so that sorting their names sorts them by age.
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is a log output which writes to a file, and starts a new one when the file would
+// grow beyond a maximum size. The full file is renamed with a timestamp, such as
+// api-20240102T150405.000.log, optionally gzipped, and only the newest backups are kept. It is
+// safe for concurrent use.
+type RotatingFile struct {
+	filename   string
+	maxSize    int64
+	maxBackups int
+	compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	// lastBackup is the time in the name of the newest backup.
+	lastBackup time.Time
+
+	// wg tracks the goroutines compressing and pruning backups.
+	wg sync.WaitGroup
+}
+
+// NewRotatingFile opens filename for appending, creating it and its directory if needed. A
+// maxSize of zero never rotates, and a maxBackups of zero keeps every backup.
+func NewRotatingFile(filename string, maxSize int64, maxBackups int, compress bool) (*RotatingFile, error) {
+	f := &RotatingFile{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		compress:   compress,
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.filename), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p doesn't fit. An entry larger than the
+// maximum size is still written, to a file of its own.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	// If the file can't be rotated, the entry is still appended to the full one if possible.
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil && f.file == nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate starts a new file straight away, for example after an external tool asked for it.
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.rotate()
+}
+
+func (f *RotatingFile) rotate() error {
+	// The old file is given up on even if closing it fails, since it can't be written to
+	// afterwards either way.
+	closeErr := f.file.Close()
+	f.file = nil
+
+	backup := f.backupName(time.Now().UTC())
+	if err := os.Rename(f.filename, backup); err != nil {
+		if oerr := f.open(); oerr != nil {
+			return oerr
+		}
+		return errors.Join(closeErr, err)
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	// Compressing can take a while for large files, so it doesn't hold up the writers.
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		if f.compress {
+			if err := compressFile(backup); err != nil {
+				return
+			}
+		}
+		f.prune()
+	}()
+
+	return closeErr
+}
+
+// backupName returns an unused name for a backup made at t. Should two rotations happen within
+// the same millisecond, the second gets the next millisecond. The name is always later than the
+// previous backup's, even if the earlier name is free again because prune removed that backup:
+// otherwise the newest backup would sort as the oldest, and be pruned next.
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.filename)
+	base := strings.TrimSuffix(f.filename, ext)
+
+	t = t.Truncate(time.Millisecond)
+	if !t.After(f.lastBackup) {
+		t = f.lastBackup.Add(time.Millisecond)
+	}
+
+	for {
+		name := base + "-" + t.Format(backupTimeFormat) + ext
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if _, err := os.Stat(name + ".gz"); os.IsNotExist(err) {
+				f.lastBackup = t
+				return name
+			}
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// compressFile replaces name with a gzipped name.gz.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+
+	return os.Remove(name)
+}
+
+// prune removes the oldest backups beyond maxBackups.
+func (f *RotatingFile) prune() {
+	if f.maxBackups <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(f.filename)
+	matches, err := filepath.Glob(strings.TrimSuffix(f.filename, ext) + "-*" + ext + "*")
+	if err != nil {
+		return
+	}
+
+	// A backup which is being compressed exists both with and without .gz for a moment, and is
+	// counted once.
+	backups := make(map[string][]string)
+	for _, match := range matches {
+		key := strings.TrimSuffix(match, ".gz")
+		backups[key] = append(backups[key], match)
+	}
+
+	keys := make([]string, 0, len(backups))
+	for key := range backups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i := 0; i < len(keys)-f.maxBackups; i++ {
+		for _, name := range backups[keys[i]] {
+			os.Remove(name)
+		}
+	}
+}
+
+// Flush waits for backups still being compressed, and commits the file to disk.
+func (f *RotatingFile) Flush() error {
+	f.wg.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close flushes and closes the file. Later writes fail with os.ErrClosed.
+func (f *RotatingFile) Close() error {
+	f.wg.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
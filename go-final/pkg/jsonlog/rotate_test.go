@@ -0,0 +1,260 @@
+package jsonlog
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// line returns a log line of exactly n bytes, newline included.
+func line(c byte, n int) []byte {
+	return append([]byte(strings.Repeat(string(c), n-1)), '\n')
+}
+
+// backups returns the names of the rotated files next to filename, oldest first.
+func backups(t *testing.T, filename string) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(strings.TrimSuffix(filename, ".log") + "-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(matches)
+
+	return matches
+}
+
+// readBackup returns the contents of a backup, decompressing it if needed.
+func readBackup(t *testing.T, name string) string {
+	t.Helper()
+
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "logs", "api.log")
+
+	f, err := NewRotatingFile(filename, 100, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Two 40 byte lines fit in 100 bytes, the third starts a new file.
+	for _, c := range []byte("abcde") {
+		if _, err := f.Write(line(c, 40)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := backups(t, filename)
+	if len(got) != 2 {
+		t.Fatalf("got backups %v; want 2", got)
+	}
+
+	for i, want := range []string{"ab", "cd"} {
+		if content := readBackup(t, got[i]); content != string(line(want[0], 40))+string(line(want[1], 40)) {
+			t.Errorf("got backup %d with %q; want lines of %c and %c", i, content, want[0], want[1])
+		}
+	}
+	if content := readFile(t, filename); content != string(line('e', 40)) {
+		t.Errorf("got current file with %q; want the last line", content)
+	}
+}
+
+func TestRotatingFileLargeEntry(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "api.log")
+
+	f, err := NewRotatingFile(filename, 50, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write(line('a', 10))
+	f.Write(line('b', 80))
+	f.Write(line('c', 10))
+	f.Flush()
+
+	// The large entry goes into a file of its own rather than being split or dropped.
+	got := backups(t, filename)
+	if len(got) != 2 || readBackup(t, got[1]) != string(line('b', 80)) {
+		t.Errorf("got backups %v; want the large entry alone in the second", got)
+	}
+}
+
+func TestRotatingFileExisting(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "api.log")
+	if err := os.WriteFile(filename, line('a', 40), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewRotatingFile(filename, 100, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The size of what was there before counts.
+	f.Write(line('b', 40))
+	f.Write(line('c', 40))
+	f.Flush()
+
+	if got := backups(t, filename); len(got) != 1 || readBackup(t, got[0]) != string(line('a', 40))+string(line('b', 40)) {
+		t.Errorf("got backups %v; want one with the old and the first new line", got)
+	}
+}
+
+func TestRotatingFilePrune(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		name := "plain"
+		if compress {
+			name = "gzip"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "api.log")
+
+			f, err := NewRotatingFile(filename, 10, 2, compress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			// Every line fills a file, so each write after the first rotates.
+			for _, c := range []byte("abcdef") {
+				f.Write(line(c, 10))
+				// Compression and pruning run in the background; let each finish, so that the
+				// order in which they do is the order of the rotations.
+				f.Flush()
+			}
+
+			got := backups(t, filename)
+			if len(got) != 2 {
+				t.Fatalf("got backups %v; want the newest 2", got)
+			}
+
+			for i, c := range []byte("de") {
+				if strings.HasSuffix(got[i], ".gz") != compress {
+					t.Errorf("got backup %s; want compressed %t", got[i], compress)
+				}
+				if content := readBackup(t, got[i]); content != string(line(c, 10)) {
+					t.Errorf("got backup %s with %q; want a line of %c", got[i], content, c)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFileRotate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "api.log")
+
+	f, err := NewRotatingFile(filename, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Without a maximum size, only Rotate starts a new file.
+	for i := 0; i < 100; i++ {
+		f.Write(line('a', 100))
+	}
+	if got := backups(t, filename); len(got) != 0 {
+		t.Fatalf("got backups %v; want none", got)
+	}
+
+	if err := f.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write(line('b', 10))
+	f.Flush()
+
+	if got := backups(t, filename); len(got) != 1 {
+		t.Errorf("got backups %v; want 1", got)
+	}
+	if content := readFile(t, filename); content != string(line('b', 10)) {
+		t.Errorf("got current file with %q; want the line written after Rotate", content)
+	}
+}
+
+func TestRotatingFileCloseError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "api.log")
+
+	f, err := NewRotatingFile(filename, 20, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write(line('a', 10))
+
+	// Closing the file behind the writer's back makes closing it during the rotation fail.
+	f.file.Close()
+
+	if _, err := f.Write(line('b', 15)); err != nil {
+		t.Fatalf("got error %v writing after a failed close; want the entry written to a new file", err)
+	}
+	if _, err := f.Write(line('c', 4)); err != nil {
+		t.Fatal(err)
+	}
+	f.Flush()
+
+	if content := readFile(t, filename); content != string(line('b', 15))+string(line('c', 4)) {
+		t.Errorf("got current file with %q; want both later lines", content)
+	}
+}
+
+func TestRotatingFileClosed(t *testing.T) {
+	f, err := NewRotatingFile(filepath.Join(t.TempDir(), "api.log"), 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(line('a', 10)); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("got error %v; want %v", err, os.ErrClosed)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("got error %v closing twice; want none", err)
+	}
+}
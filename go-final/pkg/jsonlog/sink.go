@@ -0,0 +1,220 @@
+package jsonlog
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Flusher is implemented by outputs which buffer entries, such as Async. Logger.Flush flushes
+// its output if it is one.
+type Flusher interface {
+	Flush() error
+}
+
+// SyncWriter is implemented by outputs which queue entries, such as Async. Logger writes FATAL
+// entries with WriteSync, so that the entry explaining why the application exits is never
+// dropped.
+type SyncWriter interface {
+	WriteSync(p []byte) (int, error)
+}
+
+// Multi is a log output which writes every entry to several outputs, such as the console and a
+// file.
+type Multi []io.Writer
+
+// NewMulti returns an output writing to all of outs.
+func NewMulti(outs ...io.Writer) Multi {
+	return Multi(outs)
+}
+
+// Write writes p to every output. Unlike io.MultiWriter, a failing output doesn't stop the
+// others from receiving the entry; the errors are joined.
+func (m Multi) Write(p []byte) (int, error) {
+	var errs []error
+	for _, out := range m {
+		if _, err := out.Write(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return len(p), errors.Join(errs...)
+}
+
+// WriteSync is like Write, but writes through the queue of any output which has one.
+func (m Multi) WriteSync(p []byte) (int, error) {
+	var errs []error
+	for _, out := range m {
+		var err error
+		if sw, ok := out.(SyncWriter); ok {
+			_, err = sw.WriteSync(p)
+		} else {
+			_, err = out.Write(p)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return len(p), errors.Join(errs...)
+}
+
+// Flush flushes every output which buffers entries.
+func (m Multi) Flush() error {
+	var errs []error
+	for _, out := range m {
+		if f, ok := out.(Flusher); ok {
+			if err := f.Flush(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Async is a log output which queues entries and writes them to another output from a
+// goroutine of its own, so that logging doesn't wait for a slow console or disk. The queue is
+// bounded: when it is full, entries are dropped rather than holding up the caller, and counted.
+// Entries written with WriteSync are the exception, see SyncWriter.
+type Async struct {
+	out   io.Writer
+	queue chan asyncEntry
+
+	// outMu keeps WriteSync and the goroutine from writing to out at the same time.
+	outMu sync.Mutex
+
+	dropped atomic.Uint64
+	errors  atomic.Uint64
+
+	// mu keeps Write from sending on the queue after Close has closed it.
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// asyncEntry is either a log entry, or a flush request which is answered once every entry
+// queued before it has been written.
+type asyncEntry struct {
+	line    []byte
+	flushed chan struct{}
+}
+
+// NewAsync returns an output which writes to out through a queue of up to size entries.
+func NewAsync(out io.Writer, size int) *Async {
+	a := &Async{
+		out:   out,
+		queue: make(chan asyncEntry, size),
+		done:  make(chan struct{}),
+	}
+
+	go a.run()
+
+	return a
+}
+
+func (a *Async) run() {
+	defer close(a.done)
+
+	for entry := range a.queue {
+		if entry.flushed != nil {
+			close(entry.flushed)
+			continue
+		}
+
+		a.outMu.Lock()
+		_, err := a.out.Write(entry.line)
+		a.outMu.Unlock()
+
+		if err != nil {
+			a.errors.Add(1)
+		}
+	}
+}
+
+// Write queues a copy of p. It never blocks: if the queue is full, or the output has been
+// closed, the entry is dropped.
+func (a *Async) Write(p []byte) (int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		a.dropped.Add(1)
+		return len(p), nil
+	}
+
+	select {
+	case a.queue <- asyncEntry{line: append([]byte(nil), p...)}:
+	default:
+		a.dropped.Add(1)
+	}
+
+	return len(p), nil
+}
+
+// WriteSync writes p straight to the underlying output, after the entries queued so far. It
+// waits for room in the queue and for the output, and works after Close too.
+func (a *Async) WriteSync(p []byte) (int, error) {
+	a.wait()
+
+	a.outMu.Lock()
+	defer a.outMu.Unlock()
+
+	return a.out.Write(p)
+}
+
+// Flush waits until every entry queued so far has been written, and then flushes the
+// underlying output if it buffers too.
+func (a *Async) Flush() error {
+	a.wait()
+
+	if f, ok := a.out.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// wait returns once every entry queued so far has been written.
+func (a *Async) wait() {
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		return
+	}
+
+	flushed := make(chan struct{})
+	a.queue <- asyncEntry{flushed: flushed}
+	a.mu.RUnlock()
+
+	<-flushed
+}
+
+// Close writes the queued entries and stops the goroutine. Entries written afterwards are
+// dropped. The underlying output is flushed, but not closed.
+func (a *Async) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	<-a.done
+
+	if f, ok := a.out.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Dropped returns how many entries were dropped because the queue was full, or because they
+// were written after Close.
+func (a *Async) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Errors returns how many entries the underlying output failed to write.
+func (a *Async) Errors() uint64 {
+	return a.errors.Load()
+}
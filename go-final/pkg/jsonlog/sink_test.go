@@ -0,0 +1,274 @@
+package jsonlog
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder is an output which records the entries written to it and the calls to Flush, in
+// order. It fails with err if set, and waits on gate before every write if it isn't nil,
+// announcing the write on started first.
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+	err    error
+
+	gate    chan struct{}
+	started chan struct{}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.gate != nil {
+		r.started <- struct{}{}
+		<-r.gate
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return 0, r.err
+	}
+	r.events = append(r.events, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func (r *recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, "flush")
+	return r.err
+}
+
+func (r *recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return strings.Join(r.events, ",")
+}
+
+// blockedAsync returns an Async with a queue of size whose output is stuck writing the entry
+// "first" until the returned function is called. Further writes to the output go through.
+func blockedAsync(t *testing.T, size int) (*Async, *recorder, func()) {
+	t.Helper()
+
+	out := &recorder{gate: make(chan struct{}), started: make(chan struct{})}
+	a := NewAsync(out, size)
+
+	a.Write([]byte("first\n"))
+	<-out.started
+
+	var once sync.Once
+	unblock := func() {
+		once.Do(func() {
+			// Let every later write through without waiting.
+			go func() {
+				for range out.started {
+				}
+			}()
+			close(out.gate)
+		})
+	}
+	t.Cleanup(unblock)
+
+	return a, out, unblock
+}
+
+func TestAsyncDropsWhenFull(t *testing.T) {
+	a, out, unblock := blockedAsync(t, 2)
+
+	for _, entry := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := a.Write([]byte(entry + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The queue holds a and b; the rest don't fit, and the writer doesn't wait for room.
+	if got := a.Dropped(); got != 3 {
+		t.Errorf("got %d dropped; want 3", got)
+	}
+
+	unblock()
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := out.String(); got != "first,a,b,flush" {
+		t.Errorf("got %q; want the queued entries in order, then a flush", got)
+	}
+}
+
+func TestAsyncWriteSync(t *testing.T) {
+	a, out, unblock := blockedAsync(t, 1)
+	a.Write([]byte("a\n"))
+
+	done := make(chan struct{})
+	go func() {
+		a.WriteSync([]byte("fatal\n"))
+		close(done)
+	}()
+
+	// The queue is full, but the entry waits rather than being dropped.
+	unblock()
+	<-done
+
+	if got := out.String(); got != "first,a,fatal" {
+		t.Errorf("got %q; want the entry after the queued ones", got)
+	}
+	if got := a.Dropped(); got != 0 {
+		t.Errorf("got %d dropped; want 0", got)
+	}
+
+	a.Close()
+	a.WriteSync([]byte("after close\n"))
+
+	if got := out.String(); got != "first,a,fatal,flush,after close" {
+		t.Errorf("got %q; want an entry written after Close too", got)
+	}
+}
+
+func TestLoggerFatalBypassesQueue(t *testing.T) {
+	a, out, unblock := blockedAsync(t, 1)
+	logger := NewLogger(a, LevelInfo)
+
+	logger.PrintInfo("queued", nil)
+	logger.PrintInfo("dropped", nil)
+
+	done := make(chan struct{})
+	go func() {
+		// What PrintFatal writes before it exits.
+		logger.print(LevelFatal, "shutting down", nil)
+		close(done)
+	}()
+
+	unblock()
+	<-done
+
+	got := out.String()
+	if !strings.Contains(got, "queued") || !strings.Contains(got, `"level":"FATAL"`) {
+		t.Errorf("got %q; want the queued and the FATAL entry", got)
+	}
+	if strings.Index(got, "queued") > strings.Index(got, "FATAL") {
+		t.Errorf("got %q; want the FATAL entry after the queued one", got)
+	}
+	if got := a.Dropped(); got != 1 {
+		t.Errorf("got %d dropped; want only the INFO entry which didn't fit", got)
+	}
+}
+
+func TestAsyncClose(t *testing.T) {
+	out := &recorder{}
+	a := NewAsync(out, 10)
+
+	a.Write([]byte("a\n"))
+	a.Write([]byte("b\n"))
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close writes what was queued, then flushes the output.
+	if got := out.String(); got != "a,b,flush" {
+		t.Errorf("got %q after Close; want a,b,flush", got)
+	}
+
+	a.Write([]byte("c\n"))
+	if got := a.Dropped(); got != 1 {
+		t.Errorf("got %d dropped after Close; want 1", got)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Errorf("got error %v closing twice; want none", err)
+	}
+	if err := a.Flush(); err != nil {
+		t.Errorf("got error %v flushing after Close; want none", err)
+	}
+	if got := out.String(); got != "a,b,flush,flush,flush" {
+		t.Errorf("got %q; want no entries after Close", got)
+	}
+}
+
+func TestAsyncErrors(t *testing.T) {
+	out := &recorder{err: errors.New("disk full")}
+	a := NewAsync(out, 10)
+
+	a.Write([]byte("a\n"))
+	a.Write([]byte("b\n"))
+
+	if err := a.Flush(); !errors.Is(err, out.err) {
+		t.Errorf("got error %v from Flush; want %v", err, out.err)
+	}
+	if got := a.Errors(); got != 2 {
+		t.Errorf("got %d errors; want 2", got)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	var first, last bytes.Buffer
+	failing := &recorder{err: errors.New("disk full")}
+	m := NewMulti(&first, failing, &last)
+
+	n, err := m.Write([]byte("entry\n"))
+
+	if n != len("entry\n") {
+		t.Errorf("got %d bytes written; want %d", n, len("entry\n"))
+	}
+	if !errors.Is(err, failing.err) {
+		t.Errorf("got error %v; want %v", err, failing.err)
+	}
+
+	// A failing output doesn't keep the entry from the others.
+	for name, buf := range map[string]*bytes.Buffer{"first": &first, "last": &last} {
+		if buf.String() != "entry\n" {
+			t.Errorf("got %q in the %s output; want the entry", buf.String(), name)
+		}
+	}
+}
+
+func TestMultiJoinsErrors(t *testing.T) {
+	errA, errB := errors.New("disk full"), errors.New("broken pipe")
+	a, b := &recorder{err: errA}, &recorder{err: errB}
+	ok := &recorder{}
+	m := NewMulti(a, ok, b)
+
+	if _, err := m.Write([]byte("entry\n")); !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("got error %v from Write; want both errors", err)
+	}
+
+	err := m.Flush()
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("got error %v from Flush; want both errors", err)
+	}
+	if got := ok.String(); got != "entry,flush" {
+		t.Errorf("got %q; want the working output written and flushed", got)
+	}
+
+	if _, err := NewMulti(ok).Write([]byte("again\n")); err != nil {
+		t.Errorf("got error %v with no failing output; want none", err)
+	}
+}
+
+func TestMultiWriteSync(t *testing.T) {
+	a, out, unblock := blockedAsync(t, 1)
+	var console bytes.Buffer
+	m := NewMulti(&console, a)
+
+	a.Write([]byte("a\n"))
+
+	done := make(chan struct{})
+	go func() {
+		m.WriteSync([]byte("fatal\n"))
+		close(done)
+	}()
+
+	unblock()
+	<-done
+
+	if console.String() != "fatal\n" || out.String() != "first,a,fatal" {
+		t.Errorf("got %q and %q; want the entry in both outputs", console.String(), out.String())
+	}
+}
@@ -0,0 +1,129 @@
+// Package lockout protects login endpoints against password guessing. It counts failed
+// attempts per key (an account, an IP address, ...) and, once a threshold is reached, locks the
+// key for a time that doubles with every further failure.
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// Attempts is the state kept for a single key.
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// Store persists attempts. Implementations must make Increment atomic, since concurrent login
+// requests for the same account are exactly what this package defends against.
+type Store interface {
+	// Get returns the attempts recorded for key, or the zero Attempts if there are none.
+	Get(ctx context.Context, key string) (Attempts, error)
+	// Increment records a failure at now and returns the updated attempts. Failures older than
+	// window are forgotten and the count starts again from one.
+	Increment(ctx context.Context, key string, now time.Time, window time.Duration) (Attempts, error)
+	// Lock locks key until the given time.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Reset forgets everything about key.
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy decides when a key is locked and for how long.
+type Policy struct {
+	// Threshold is the number of failures after which the key is locked.
+	Threshold int
+	// BaseLockout is the lockout after Threshold failures. Each further failure doubles it.
+	BaseLockout time.Duration
+	// MaxLockout caps the lockout.
+	MaxLockout time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// Lockout returns how long a key with the given number of failures should be locked for.
+func (p Policy) Lockout(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	d := p.BaseLockout
+	for i := p.Threshold; i < failures && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxLockout {
+		d = p.MaxLockout
+	}
+
+	return d
+}
+
+// Clock tells the current time. It exists so that tests can use a fake clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Guard applies a policy on top of a store.
+type Guard struct {
+	store Store
+	// Clock decides when failures happen and locks end. New sets it to the system clock.
+	Clock Clock
+}
+
+// New returns a Guard that keeps its state in store.
+func New(store Store) *Guard {
+	return &Guard{store: store, Clock: systemClock{}}
+}
+
+// Check returns how long the caller has to wait before trying again, or zero if none of the
+// keys is locked.
+func (g *Guard) Check(ctx context.Context, keys ...string) (time.Duration, error) {
+	now := g.Clock.Now()
+
+	var wait time.Duration
+	for _, key := range keys {
+		attempts, err := g.store.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		if d := attempts.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait, nil
+}
+
+// Fail records a failed attempt for key under the given policy. If the key gets locked, it
+// returns the updated attempts and true.
+func (g *Guard) Fail(ctx context.Context, key string, policy Policy) (Attempts, bool, error) {
+	now := g.Clock.Now()
+
+	attempts, err := g.store.Increment(ctx, key, now, policy.Window)
+	if err != nil {
+		return Attempts{}, false, err
+	}
+
+	lockout := policy.Lockout(attempts.Failures)
+	if lockout == 0 {
+		return attempts, false, nil
+	}
+
+	attempts.LockedUntil = now.Add(lockout)
+	if err := g.store.Lock(ctx, key, attempts.LockedUntil); err != nil {
+		return Attempts{}, false, err
+	}
+
+	return attempts, true, nil
+}
+
+// Reset clears the failures of key, after a successful login or when an admin unlocks it.
+func (g *Guard) Reset(ctx context.Context, key string) error {
+	return g.store.Reset(ctx, key)
+}
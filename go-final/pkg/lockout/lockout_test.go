@@ -0,0 +1,164 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// fakeClock is a clock which only moves when the test advances it.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+var testPolicy = Policy{
+	Threshold:   3,
+	BaseLockout: time.Minute,
+	MaxLockout:  10 * time.Minute,
+	Window:      15 * time.Minute,
+}
+
+func TestPolicyLockout(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := testPolicy.Lockout(tt.failures); got != tt.want {
+			t.Errorf("%d failures: got %s; want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testGuard(t, NewMemoryStore(), "account:harry@example.com")
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("TEST_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := NewPostgresStore(db, time.Second)
+	key := "test:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	t.Cleanup(func() {
+		store.Reset(context.Background(), key)
+		store.Reset(context.Background(), key+":other")
+	})
+
+	testGuard(t, store, key)
+}
+
+// testGuard runs a sequence of failed logins for key against a Guard on store.
+func testGuard(t *testing.T, store Store, key string) {
+	ctx := context.Background()
+
+	// Whole seconds, since Postgres stores no more.
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	g := New(store)
+	g.Clock = clock
+
+	check := func(want time.Duration, keys ...string) {
+		t.Helper()
+
+		wait, err := g.Check(ctx, keys...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != want {
+			t.Errorf("got wait %s; want %s", wait, want)
+		}
+	}
+
+	fail := func(wantFailures int, wantLockout time.Duration) {
+		t.Helper()
+
+		attempts, locked, err := g.Fail(ctx, key, testPolicy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts.Failures != wantFailures {
+			t.Errorf("got %d failures; want %d", attempts.Failures, wantFailures)
+		}
+		if locked != (wantLockout > 0) {
+			t.Errorf("got locked %t; want %t", locked, wantLockout > 0)
+		}
+		if locked && !attempts.LockedUntil.Equal(clock.now.Add(wantLockout)) {
+			t.Errorf("got locked until %s; want %s", attempts.LockedUntil, clock.now.Add(wantLockout))
+		}
+	}
+
+	// Below the threshold nothing is locked.
+	fail(1, 0)
+	clock.advance(time.Second)
+	fail(2, 0)
+	check(0, key)
+
+	// The threshold locks the key for the base lockout, which runs out.
+	clock.advance(time.Second)
+	fail(3, time.Minute)
+	check(time.Minute, key)
+
+	clock.advance(30 * time.Second)
+	check(30*time.Second, key)
+
+	// Every further failure doubles the lockout, up to the maximum.
+	fail(4, 2*time.Minute)
+	fail(5, 4*time.Minute)
+	fail(6, 8*time.Minute)
+	fail(7, 10*time.Minute)
+	fail(8, 10*time.Minute)
+	check(10*time.Minute, key)
+
+	// Checking several keys waits for the longest lock.
+	check(10*time.Minute, key+":other", key)
+	check(0, key+":other")
+
+	clock.advance(10 * time.Minute)
+	check(0, key)
+
+	// Failures are forgotten once the window has passed since the last one.
+	clock.advance(testPolicy.Window + time.Second)
+	fail(1, 0)
+
+	// A successful login forgets them straight away.
+	fail(2, 0)
+	if err := g.Reset(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	fail(1, 0)
+
+	// Resetting a locked key unlocks it.
+	fail(2, 0)
+	fail(3, time.Minute)
+	if err := g.Reset(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	check(0, key)
+}
@@ -0,0 +1,68 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps attempts in process memory. It is enough for a single instance; with more
+// than one replica each of them counts separately, so use PostgresStore there.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: make(map[string]Attempts)}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.attempts[key], nil
+}
+
+func (s *MemoryStore) Increment(_ context.Context, key string, now time.Time, window time.Duration) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget keys whose last failure is outside the window and which are no longer locked, so
+	// that the map doesn't keep every IP address that ever mistyped a password.
+	for k, a := range s.attempts {
+		if now.Sub(a.LastFailure) > window && now.After(a.LockedUntil) {
+			delete(s.attempts, k)
+		}
+	}
+
+	a := s.attempts[key]
+	if now.Sub(a.LastFailure) > window {
+		a.Failures = 0
+	}
+
+	a.Failures++
+	a.LastFailure = now
+	s.attempts[key] = a
+
+	return a, nil
+}
+
+func (s *MemoryStore) Lock(_ context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.attempts[key]
+	a.LockedUntil = until
+	s.attempts[key] = a
+
+	return nil
+}
+
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,94 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresStore keeps attempts in the login_attempts table, so that all replicas of the API
+// share them.
+type PostgresStore struct {
+	DB *sql.DB
+	// Timeout bounds each query, on top of the deadline of the context passed in.
+	Timeout time.Duration
+}
+
+func NewPostgresStore(db *sql.DB, timeout time.Duration) *PostgresStore {
+	return &PostgresStore{DB: db, Timeout: timeout}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (Attempts, error) {
+	query := `
+		SELECT failures, last_failure, COALESCE(locked_until, 'epoch')
+		FROM login_attempts
+		WHERE key = $1
+		`
+
+	var a Attempts
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, query, key).Scan(&a.Failures, &a.LastFailure, &a.LockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Attempts{}, err
+	}
+
+	return a, nil
+}
+
+func (s *PostgresStore) Increment(ctx context.Context, key string, now time.Time, window time.Duration) (Attempts, error) {
+	// A single upsert keeps the increment atomic across concurrent requests and replicas.
+	query := `
+		INSERT INTO login_attempts (key, failures, last_failure)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.last_failure < $3 THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure = EXCLUDED.last_failure
+		RETURNING failures, last_failure, COALESCE(locked_until, 'epoch')
+		`
+
+	var a Attempts
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, query, key, now, now.Add(-window)).Scan(&a.Failures, &a.LastFailure, &a.LockedUntil)
+	if err != nil {
+		return Attempts{}, err
+	}
+
+	return a, nil
+}
+
+func (s *PostgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `
+		UPDATE login_attempts
+		SET locked_until = $2
+		WHERE key = $1
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, query, key, until)
+	return err
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE key = $1
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, query, key)
+	return err
+}
@@ -0,0 +1,229 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// value is a float64 which can be updated atomically.
+type value struct {
+	bits atomic.Uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) { v.bits.Store(math.Float64bits(f)) }
+func (v *value) get() float64  { return math.Float64frombits(v.bits.Load()) }
+
+// vec holds one value per combination of label values.
+type vec struct {
+	desc
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value
+}
+
+func (v *vec) get(values []string) *series {
+	checkLabels(v.desc, values)
+	key := seriesKey(values)
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if s, ok := v.series[key]; ok {
+		return s
+	}
+	s = &series{labels: append([]string(nil), values...)}
+	v.series[key] = s
+	return s
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if len(v.series) == 0 {
+		return
+	}
+
+	v.header(w)
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labelString(v.labels, s.labels), formatFloat(s.get()))
+	}
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct{ vec }
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec{desc: desc{name, help, "counter", labels}, series: make(map[string]*series)}}
+	r.register(name, c)
+	return c
+}
+
+// Add adds delta, which must not be negative, to the series with the given label values.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.get(labelValues).add(delta)
+}
+
+// Inc adds one to the series with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge is a value that goes up and down, such as a number of requests in flight.
+type Gauge struct{ vec }
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec{desc: desc{name, help, "gauge", labels}, series: make(map[string]*series)}}
+	r.register(name, g)
+	return g
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) { g.get(labelValues).add(delta) }
+func (g *Gauge) Set(f float64, labelValues ...string)     { g.get(labelValues).set(f) }
+func (g *Gauge) Inc(labelValues ...string)                { g.Add(1, labelValues...) }
+func (g *Gauge) Dec(labelValues ...string)                { g.Add(-1, labelValues...) }
+
+// funcMetric reads its value when the metrics are written, for values kept elsewhere such as
+// the database pool statistics.
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.header(w)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+}
+
+// NewGaugeFunc registers a gauge whose value is fn's result at the time of writing.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{desc{name: name, help: help, typ: "gauge"}, fn})
+}
+
+// NewCounterFunc registers a counter whose value is fn's result at the time of writing. fn
+// must never return less than it did before.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{desc{name: name, help: help, typ: "counter"}, fn})
+}
+
+// Histogram counts observations, such as request durations, in buckets.
+type Histogram struct {
+	desc
+	buckets []float64
+
+	mu     sync.RWMutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    value
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{
+		desc:    desc{name, help, "histogram", labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(name, h)
+	return h
+}
+
+// Observe records a value in the series with the given label values.
+func (h *Histogram) Observe(f float64, labelValues ...string) {
+	s := h.get(labelValues)
+
+	// Only the first matching bucket is counted; write makes the counts cumulative.
+	if i := sort.SearchFloat64s(h.buckets, f); i < len(h.buckets) {
+		s.counts[i].Add(1)
+	}
+	s.count.Add(1)
+	s.sum.add(f)
+}
+
+func (h *Histogram) get(values []string) *histogramSeries {
+	checkLabels(h.desc, values)
+	key := seriesKey(values)
+
+	h.mu.RLock()
+	s, ok := h.series[key]
+	h.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if s, ok := h.series[key]; ok {
+		return s
+	}
+	s = &histogramSeries{
+		labels: append([]string(nil), values...),
+		counts: make([]atomic.Uint64, len(h.buckets)),
+	}
+	h.series[key] = s
+	return s
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.series) == 0 {
+		return
+	}
+
+	h.header(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i].Load()
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, s.labels, "le", formatFloat(bound)), cumulative)
+		}
+
+		count := s.count.Load()
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, s.labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, s.labels), formatFloat(s.sum.get()))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, s.labels), count)
+	}
+}
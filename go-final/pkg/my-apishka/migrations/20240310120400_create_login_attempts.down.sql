@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'lockouts:write';
+
+DROP TABLE IF EXISTS login_attempts;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS login_attempts
+(
+    key          text PRIMARY KEY,
+    failures     integer NOT NULL DEFAULT 0,
+    last_failure timestamp(0) with time zone NOT NULL,
+    locked_until timestamp(0) with time zone
+);
+
+INSERT INTO permissions (code)
+VALUES ('lockouts:write');
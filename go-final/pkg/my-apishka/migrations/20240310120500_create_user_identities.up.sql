@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_identities
+(
+    provider   text NOT NULL,
+    subject    text NOT NULL,
+    user_id    bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    email      citext NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
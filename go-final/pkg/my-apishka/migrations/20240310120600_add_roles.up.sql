@@ -0,0 +1,45 @@
+CREATE UNIQUE INDEX IF NOT EXISTS permissions_code_idx ON permissions (code);
+
+CREATE TABLE IF NOT EXISTS roles
+(
+    id   BIGSERIAL PRIMARY KEY,
+    name text UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions
+(
+    role_id       BIGINT NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles
+(
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id BIGINT NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+-- comments:write was already checked by the routes but never seeded. Codes ending in ":*" and
+-- the lone "*" are wildcards, see Permissions.Include.
+INSERT INTO permissions (code)
+VALUES ('comments:write'),
+       ('permissions:write'),
+       ('characters:*'),
+       ('comments:*'),
+       ('*')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO roles (name)
+VALUES ('viewer'),
+       ('editor'),
+       ('moderator'),
+       ('admin');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE (roles.name = 'viewer' AND permissions.code = 'characters:read')
+   OR (roles.name = 'editor' AND permissions.code = 'characters:*')
+   OR (roles.name = 'moderator' AND permissions.code IN ('characters:read', 'comments:*'))
+   OR (roles.name = 'admin' AND permissions.code = '*');
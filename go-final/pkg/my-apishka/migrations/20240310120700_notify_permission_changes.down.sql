@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS permissions_changed ON permissions;
+DROP TRIGGER IF EXISTS roles_permissions_changed ON roles_permissions;
+DROP TRIGGER IF EXISTS users_roles_truncated ON users_roles;
+DROP TRIGGER IF EXISTS users_permissions_truncated ON users_permissions;
+DROP TRIGGER IF EXISTS users_roles_changed ON users_roles;
+DROP TRIGGER IF EXISTS users_permissions_changed ON users_permissions;
+DROP FUNCTION IF EXISTS notify_all_permissions_changed();
+DROP FUNCTION IF EXISTS notify_user_permissions_changed();
@@ -0,0 +1,45 @@
+-- Every change to who has which permission is announced on the permissions_changed channel, so
+-- that API instances can drop cached permissions. The payload is the affected user's ID, or "*"
+-- when a change may affect any user.
+CREATE OR REPLACE FUNCTION notify_user_permissions_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM pg_notify('permissions_changed', OLD.user_id::text);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM pg_notify('permissions_changed', NEW.user_id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_all_permissions_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('permissions_changed', '*');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_permissions_changed
+    AFTER INSERT OR UPDATE OR DELETE ON users_permissions
+    FOR EACH ROW EXECUTE FUNCTION notify_user_permissions_changed();
+
+CREATE TRIGGER users_roles_changed
+    AFTER INSERT OR UPDATE OR DELETE ON users_roles
+    FOR EACH ROW EXECUTE FUNCTION notify_user_permissions_changed();
+
+CREATE TRIGGER users_permissions_truncated
+    AFTER TRUNCATE ON users_permissions
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_all_permissions_changed();
+
+CREATE TRIGGER users_roles_truncated
+    AFTER TRUNCATE ON users_roles
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_all_permissions_changed();
+
+CREATE TRIGGER roles_permissions_changed
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON roles_permissions
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_all_permissions_changed();
+
+CREATE TRIGGER permissions_changed
+    AFTER UPDATE OR DELETE OR TRUNCATE ON permissions
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_all_permissions_changed();
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'houses:all';
+DROP TABLE IF EXISTS users_houses;
+ALTER TABLE comments DROP COLUMN IF EXISTS CreatedAt;
@@ -0,0 +1,15 @@
+-- Comments written before this migration get the time it ran as their creation time.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS CreatedAt timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+-- The houses whose characters an editor may edit.
+CREATE TABLE IF NOT EXISTS users_houses
+(
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    house   text   NOT NULL,
+    PRIMARY KEY (user_id, house)
+);
+
+-- houses:all lets the holder edit characters of every house.
+INSERT INTO permissions (code)
+VALUES ('houses:all')
+ON CONFLICT (code) DO NOTHING;
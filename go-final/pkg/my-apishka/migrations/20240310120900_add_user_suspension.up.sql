@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS SuspendedAt timestamp(0) with time zone;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS PasswordResetRequired bool NOT NULL DEFAULT false;
+
+INSERT INTO permissions (code)
+VALUES ('users:admin')
+ON CONFLICT (code) DO NOTHING;
@@ -0,0 +1,5 @@
+ALTER TABLE users DROP COLUMN IF EXISTS DeletionScheduledAt;
+ALTER TABLE users DROP COLUMN IF EXISTS PendingEmail;
+ALTER TABLE users DROP COLUMN IF EXISTS FavouriteHouse;
+ALTER TABLE users DROP COLUMN IF EXISTS AvatarURL;
+ALTER TABLE users DROP COLUMN IF EXISTS Bio;
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS Bio text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS AvatarURL text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS FavouriteHouse text NOT NULL DEFAULT '';
+-- The new address of a requested email change, until it is confirmed.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS PendingEmail citext;
+-- When the user asked for their account to be deleted, it is removed at this time.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS DeletionScheduledAt timestamp(0) with time zone;
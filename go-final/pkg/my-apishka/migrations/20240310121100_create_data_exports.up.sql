@@ -0,0 +1,11 @@
+-- Personal data exports. A user has at most one: requesting a new export replaces the old one.
+CREATE TABLE IF NOT EXISTS data_exports
+(
+    id           bigserial PRIMARY KEY,
+    user_id      bigint UNIQUE NOT NULL REFERENCES users ON DELETE CASCADE,
+    status       text NOT NULL DEFAULT 'pending',
+    archive      bytea,
+    error        text NOT NULL DEFAULT '',
+    created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    completed_at timestamp(0) with time zone
+);
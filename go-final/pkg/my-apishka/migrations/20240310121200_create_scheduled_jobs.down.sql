@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'jobs:read';
+DROP TABLE IF EXISTS scheduled_jobs;
@@ -0,0 +1,11 @@
+-- The last run of each scheduler job, shared by all replicas so a run happens only once.
+CREATE TABLE IF NOT EXISTS scheduled_jobs
+(
+    name        text PRIMARY KEY,
+    last_slot   timestamp(0) with time zone NOT NULL,
+    last_run_at timestamp(0) with time zone NOT NULL
+);
+
+INSERT INTO permissions (code)
+VALUES ('jobs:read')
+ON CONFLICT (code) DO NOTHING;
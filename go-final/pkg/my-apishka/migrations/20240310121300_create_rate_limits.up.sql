@@ -0,0 +1,11 @@
+-- Request counts of the shared rate limiter, one row per key and fixed window.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits
+(
+    key          text NOT NULL,
+    window_start timestamp with time zone NOT NULL,
+    count        integer NOT NULL,
+    expires_at   timestamp with time zone NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_expires_at_idx ON rate_limits (expires_at);
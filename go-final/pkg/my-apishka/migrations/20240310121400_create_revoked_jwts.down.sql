@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS users_deleted ON users;
+DROP TRIGGER IF EXISTS users_status_changed ON users;
+DROP FUNCTION IF EXISTS notify_user_status_changed();
+DROP TABLE IF EXISTS revoked_jwts;
+DROP FUNCTION IF EXISTS notify_jwt_revoked();
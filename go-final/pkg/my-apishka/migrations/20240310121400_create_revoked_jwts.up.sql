@@ -0,0 +1,49 @@
+-- JWTs revoked before they expired, so that every API instance can refuse them. Rows can be
+-- deleted once the token has expired.
+CREATE TABLE IF NOT EXISTS revoked_jwts
+(
+    id     text PRIMARY KEY,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS revoked_jwts_expiry_idx ON revoked_jwts (expiry);
+
+-- Revocations and changes to whether a user may use the API are announced on the auth_changed
+-- channel. The payload is "token:<expiry>:<id>" for a revoked JWT, with the expiry in Unix
+-- seconds, or "user:<id>:<status>" where status is active, suspended, deleting or deleted.
+CREATE OR REPLACE FUNCTION notify_jwt_revoked() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('auth_changed', 'token:' || extract(epoch FROM NEW.expiry)::bigint || ':' || NEW.id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_user_status_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('auth_changed', 'user:' || OLD.ID || ':deleted');
+    ELSIF NEW.SuspendedAt IS NOT NULL THEN
+        PERFORM pg_notify('auth_changed', 'user:' || NEW.ID || ':suspended');
+    ELSIF NEW.DeletionScheduledAt IS NOT NULL THEN
+        PERFORM pg_notify('auth_changed', 'user:' || NEW.ID || ':deleting');
+    ELSE
+        PERFORM pg_notify('auth_changed', 'user:' || NEW.ID || ':active');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER revoked_jwts_inserted
+    AFTER INSERT ON revoked_jwts
+    FOR EACH ROW EXECUTE FUNCTION notify_jwt_revoked();
+
+CREATE TRIGGER users_status_changed
+    AFTER UPDATE OF SuspendedAt, DeletionScheduledAt ON users
+    FOR EACH ROW
+    WHEN (OLD.SuspendedAt IS DISTINCT FROM NEW.SuspendedAt
+        OR OLD.DeletionScheduledAt IS DISTINCT FROM NEW.DeletionScheduledAt)
+    EXECUTE FUNCTION notify_user_status_changed();
+
+CREATE TRIGGER users_deleted
+    AFTER DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION notify_user_status_changed();
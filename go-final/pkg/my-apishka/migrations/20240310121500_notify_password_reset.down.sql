@@ -0,0 +1,23 @@
+CREATE OR REPLACE FUNCTION notify_user_status_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('auth_changed', 'user:' || OLD.ID || ':deleted');
+    ELSIF NEW.SuspendedAt IS NOT NULL THEN
+        PERFORM pg_notify('auth_changed', 'user:' || NEW.ID || ':suspended');
+    ELSIF NEW.DeletionScheduledAt IS NOT NULL THEN
+        PERFORM pg_notify('auth_changed', 'user:' || NEW.ID || ':deleting');
+    ELSE
+        PERFORM pg_notify('auth_changed', 'user:' || NEW.ID || ':active');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_status_changed ON users;
+
+CREATE TRIGGER users_status_changed
+    AFTER UPDATE OF SuspendedAt, DeletionScheduledAt ON users
+    FOR EACH ROW
+    WHEN (OLD.SuspendedAt IS DISTINCT FROM NEW.SuspendedAt
+        OR OLD.DeletionScheduledAt IS DISTINCT FROM NEW.DeletionScheduledAt)
+    EXECUTE FUNCTION notify_user_status_changed();
@@ -0,0 +1,28 @@
+-- A forced password reset blocks the user's credentials like a suspension does, so it is
+-- announced on the auth_changed channel too, with the status password-reset.
+CREATE OR REPLACE FUNCTION notify_user_status_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('auth_changed', 'user:' || OLD.ID || ':deleted');
+    ELSIF NEW.SuspendedAt IS NOT NULL THEN
+        PERFORM pg_notify('auth_changed', 'user:' || NEW.ID || ':suspended');
+    ELSIF NEW.DeletionScheduledAt IS NOT NULL THEN
+        PERFORM pg_notify('auth_changed', 'user:' || NEW.ID || ':deleting');
+    ELSIF NEW.PasswordResetRequired THEN
+        PERFORM pg_notify('auth_changed', 'user:' || NEW.ID || ':password-reset');
+    ELSE
+        PERFORM pg_notify('auth_changed', 'user:' || NEW.ID || ':active');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_status_changed ON users;
+
+CREATE TRIGGER users_status_changed
+    AFTER UPDATE OF SuspendedAt, DeletionScheduledAt, PasswordResetRequired ON users
+    FOR EACH ROW
+    WHEN (OLD.SuspendedAt IS DISTINCT FROM NEW.SuspendedAt
+        OR OLD.DeletionScheduledAt IS DISTINCT FROM NEW.DeletionScheduledAt
+        OR OLD.PasswordResetRequired IS DISTINCT FROM NEW.PasswordResetRequired)
+    EXECUTE FUNCTION notify_user_status_changed();
@@ -0,0 +1,275 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go-final/pkg/my-apishka/validator"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// apiKeyTag starts every API key, so leaked keys are easy to spot in logs and by secret scanners.
+const apiKeyTag = "hpk"
+
+// APIKey is a long-lived credential for scripts and other services. The key looks like
+// "hpk_<prefix>_<secret>"; the prefix identifies the key and is stored as is, while only the
+// SHA-256 hash of the whole key is kept, like Token.Hash.
+type APIKey struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"-"`
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	Hash   []byte `json:"-"`
+	// Permissions restricts the key to a subset of its owner's permissions. A nil slice means
+	// the key can do everything its owner can.
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+}
+
+type APIKeyModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Timeouts Timeouts
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// Insert generates a new key, stores it and returns its plaintext. The plaintext is not kept
+// anywhere, so this is the only time it is available.
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) (string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	prefixBytes := make([]byte, 5)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", err
+	}
+
+	secretBytes := make([]byte, 20)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+
+	key.Prefix = strings.ToLower(encoding.EncodeToString(prefixBytes))
+	plaintext := apiKeyTag + "_" + key.Prefix + "_" + strings.ToLower(encoding.EncodeToString(secretBytes))
+
+	hash := sha256.Sum256([]byte(plaintext))
+	key.Hash = hash[:]
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+		`
+
+	var permissions interface{}
+	if key.Permissions != nil {
+		permissions = pq.Array(key.Permissions)
+	}
+
+	args := []interface{}{key.UserID, key.Name, key.Prefix, key.Hash, permissions, key.Expiry}
+
+	ctx, span := startSpan(ctx, "APIKeyModel.Insert")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// GetForKey looks up an unexpired API key from its plaintext and returns it with its owner.
+// It returns gorm.ErrRecordNotFound if the key is unknown, wrong or expired.
+func (m APIKeyModel) GetForKey(ctx context.Context, plaintext string) (*APIKey, *User, error) {
+	parts := strings.Split(plaintext, "_")
+	if len(parts) != 3 || parts[0] != apiKeyTag {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	query := `
+		SELECT
+			api_keys.id, api_keys.name, api_keys.prefix, api_keys.hash, api_keys.permissions,
+			api_keys.expiry, api_keys.created_at, api_keys.last_used_at,
+			` + userColumns + `
+		FROM api_keys
+		INNER JOIN users ON users.ID = api_keys.user_id
+		WHERE api_keys.prefix = $1
+			AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)
+		`
+
+	var key APIKey
+	var user User
+	var permissions []string
+
+	ctx, span := startSpan(ctx, "APIKeyModel.GetForKey")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	fields := []interface{}{
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		pq.Array(&permissions),
+		&key.Expiry,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+	}
+
+	err := m.DB.QueryRowContext(ctx, query, parts[1], time.Now()).Scan(append(fields, user.scanFields()...)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, gorm.ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	// The prefix only finds the row; the key is only valid if the whole thing matches.
+	hash := sha256.Sum256([]byte(plaintext))
+	if subtle.ConstantTimeCompare(hash[:], key.Hash) != 1 {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	key.UserID = user.ID
+	key.Permissions = permissions
+
+	return &key, &user, nil
+}
+
+// Touch records that the key was just used.
+func (m APIKeyModel) Touch(ctx context.Context, id int64) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1
+		`
+
+	ctx, span := startSpan(ctx, "APIKeyModel.Touch")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// GetAllForUser returns the API keys of a user, newest first.
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, name, prefix, permissions, expiry, created_at, last_used_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		`
+
+	ctx, span := startSpan(ctx, "APIKeyModel.GetAllForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key := &APIKey{UserID: userID}
+		var permissions []string
+
+		err := rows.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&permissions), &key.Expiry,
+			&key.CreatedAt, &key.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		key.Permissions = permissions
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// DeleteForUser revokes an API key of a user. It returns gorm.ErrRecordNotFound if the user has
+// no such key.
+func (m APIKeyModel) DeleteForUser(ctx context.Context, id, userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2
+		`
+
+	ctx, span := startSpan(ctx, "APIKeyModel.DeleteForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllForUser revokes every API key of a user.
+func (m APIKeyModel) DeleteAllForUser(ctx context.Context, userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE user_id = $1
+		`
+
+	ctx, span := startSpan(ctx, "APIKeyModel.DeleteAllForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
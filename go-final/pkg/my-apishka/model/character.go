@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"log"
-	"time"
 )
 
 type Character struct {
@@ -21,9 +20,10 @@ type CharacterModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	Timeouts Timeouts
 }
 
-func (c CharacterModel) Insert(character *Character) error {
+func (c CharacterModel) Insert(ctx context.Context, character *Character) error {
 	// Insert a new character item into the database.
 	query := `
 		INSERT INTO characters (FirstName, LastName, House, OriginStatus) 
@@ -31,13 +31,16 @@ func (c CharacterModel) Insert(character *Character) error {
 		RETURNING ID, CreatedAt, UpdatedAt
 		`
 	args := []interface{}{character.FirstName, character.LastName, character.House, character.OriginStatus}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "CharacterModel.Insert")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeouts.Query)
 	defer cancel()
 
 	return c.DB.QueryRowContext(ctx, query, args...).Scan(&character.ID, &character.CreatedAt, &character.UpdatedAt)
 }
 
-func (c CharacterModel) Get(id int) (*Character, error) {
+func (c CharacterModel) Get(ctx context.Context, id int) (*Character, error) {
 	// Retrieve a character item based on its ID.
 	query := `	
 		SELECT ID, CreatedAt, UpdatedAt, FirstName, LastName, House, OriginStatus
@@ -45,7 +48,10 @@ func (c CharacterModel) Get(id int) (*Character, error) {
 		WHERE ID = $1
 		`
 	var character Character
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "CharacterModel.Get")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeouts.Query)
 	defer cancel()
 
 	row := c.DB.QueryRowContext(ctx, query, id)
@@ -57,7 +63,7 @@ func (c CharacterModel) Get(id int) (*Character, error) {
 	return &character, nil
 }
 
-func (c CharacterModel) Update(character *Character) error {
+func (c CharacterModel) Update(ctx context.Context, character *Character) error {
 	// Update a character item in the database.
 	query := `
 		UPDATE characters
@@ -66,19 +72,25 @@ func (c CharacterModel) Update(character *Character) error {
 		RETURNING UpdatedAt
 		`
 	args := []interface{}{character.FirstName, character.LastName, character.House, character.ID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "CharacterModel.Update")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeouts.Query)
 	defer cancel()
 
 	return c.DB.QueryRowContext(ctx, query, args...).Scan(&character.UpdatedAt)
 }
 
-func (c CharacterModel) Delete(id int) error {
+func (c CharacterModel) Delete(ctx context.Context, id int) error {
 	// Delete a character  item from the database.
 	query := `
 		DELETE FROM characters
 		WHERE ID = $1
 		`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "CharacterModel.Delete")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeouts.Query)
 	defer cancel()
 
 	_, err := c.DB.ExecContext(ctx, query, id)
@@ -87,13 +99,16 @@ func (c CharacterModel) Delete(id int) error {
 
 // ТСИС3
 // фильтр по факультетам
-func (m *CharacterModel) GetByHouse(house string) ([]*Character, error) {
+func (m *CharacterModel) GetByHouse(ctx context.Context, house string) ([]*Character, error) {
 	query := `
 		SELECT ID, CreatedAt, UpdatedAt, FirstName, LastName, House, OriginStatus
 		FROM characters
         WHERE house = $1
     `
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, span := startSpan(ctx, "CharacterModel.GetByHouse")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, house)
@@ -121,13 +136,16 @@ func (m *CharacterModel) GetByHouse(house string) ([]*Character, error) {
 }
 
 // сортировка персонажей по фамилиям
-func (m *CharacterModel) GetByLastName() ([]*Character, error) {
+func (m *CharacterModel) GetByLastName(ctx context.Context) ([]*Character, error) {
 	query := `
 		SELECT ID, CreatedAt, UpdatedAt, FirstName, LastName, House, OriginStatus
 		FROM characters
         ORDER BY LastName
     `
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, span := startSpan(ctx, "CharacterModel.GetByLastName")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query)
@@ -155,14 +173,17 @@ func (m *CharacterModel) GetByLastName() ([]*Character, error) {
 }
 
 // берет данные по лимиту и оффсету
-func (m *CharacterModel) GetCharactersPagination(limit, offset int) ([]*Character, error) {
+func (m *CharacterModel) GetCharactersPagination(ctx context.Context, limit, offset int) ([]*Character, error) {
 	query := `
 		SELECT ID, CreatedAt, UpdatedAt, FirstName, LastName, House, OriginStatus
 		FROM characters
         ORDER BY ID
         LIMIT $1 OFFSET $2
     `
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, span := startSpan(ctx, "CharacterModel.GetCharactersPagination")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, limit, offset)
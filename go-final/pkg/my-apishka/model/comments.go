@@ -8,30 +8,35 @@ import (
 )
 
 type Comment struct {
-	Id          int    `json:"Id"`
-	UsernameID  int64  `json:"UsernameID"`
-	Comment     string `json:"Comment"`
-	CharacterID int64  `json:"CharacterID"`
+	Id          int       `json:"Id"`
+	UsernameID  int64     `json:"UsernameID"`
+	Comment     string    `json:"Comment"`
+	CharacterID int64     `json:"CharacterID"`
+	CreatedAt   time.Time `json:"CreatedAt"`
 }
 
 type CommentModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	Timeouts Timeouts
 }
 
 // CreateComment создает новый комментарий.
-func (m *CommentModel) CreateComment(comment *Comment) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *CommentModel) CreateComment(ctx context.Context, comment *Comment) error {
+	ctx, span := startSpan(ctx, "CommentModel.CreateComment")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	query := `
 		INSERT INTO comments (UsernameID, Comment, CharacterID)
 		VALUES ($1, $2, $3)
-		RETURNING Id
+		RETURNING Id, CreatedAt
 	`
 
-	err := m.DB.QueryRowContext(ctx, query, comment.UsernameID, comment.Comment, comment.CharacterID).Scan(&comment.Id)
+	err := m.DB.QueryRowContext(ctx, query, comment.UsernameID, comment.Comment, comment.CharacterID).Scan(&comment.Id, &comment.CreatedAt)
 	if err != nil {
 		m.ErrorLog.Printf("Error inserting comment into database: %v", err)
 		return err
@@ -42,19 +47,22 @@ func (m *CommentModel) CreateComment(comment *Comment) error {
 }
 
 // GetCommentByID возвращает комментарий по его ID.
-func (m *CommentModel) GetCommentByID(commentID int) (*Comment, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *CommentModel) GetCommentByID(ctx context.Context, commentID int) (*Comment, error) {
+	ctx, span := startSpan(ctx, "CommentModel.GetCommentByID")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	query := `
-		SELECT Id, UsernameID, Comment, CharacterID
+		SELECT Id, UsernameID, Comment, CharacterID, CreatedAt
 		FROM comments
 		WHERE Id = $1
 	`
 
 	comment := &Comment{}
 
-	err := m.DB.QueryRowContext(ctx, query, commentID).Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID)
+	err := m.DB.QueryRowContext(ctx, query, commentID).Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID, &comment.CreatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Комментарий не найден
@@ -66,8 +74,11 @@ func (m *CommentModel) GetCommentByID(commentID int) (*Comment, error) {
 	return comment, nil
 }
 
-func (m *CommentModel) UpdateComment(comment *Comment) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *CommentModel) UpdateComment(ctx context.Context, comment *Comment) error {
+	ctx, span := startSpan(ctx, "CommentModel.UpdateComment")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	query := `
@@ -86,8 +97,11 @@ func (m *CommentModel) UpdateComment(comment *Comment) error {
 }
 
 // DeleteCommentByID удаляет комментарий по его ID.
-func (m *CommentModel) DeleteCommentByID(commentID int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *CommentModel) DeleteCommentByID(ctx context.Context, commentID int) error {
+	ctx, span := startSpan(ctx, "CommentModel.DeleteCommentByID")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	query := `
@@ -107,15 +121,18 @@ func (m *CommentModel) DeleteCommentByID(commentID int) error {
 //фильтрация,сортировка,пагинация
 
 // фильтр по айди юзера
-func (m *CommentModel) GetCommentsByUserID(userID int64) ([]*Comment, error) {
+func (m *CommentModel) GetCommentsByUserID(ctx context.Context, userID int64) ([]*Comment, error) {
 
 	query := `
-        SELECT Id, UsernameID, Comment, CharacterID
+        SELECT Id, UsernameID, Comment, CharacterID, CreatedAt
         FROM comments
         WHERE UsernameID = $1
     `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, span := startSpan(ctx, "CommentModel.GetCommentsByUserID")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
@@ -127,7 +144,7 @@ func (m *CommentModel) GetCommentsByUserID(userID int64) ([]*Comment, error) {
 	var comments []*Comment
 	for rows.Next() {
 		comment := &Comment{}
-		err := rows.Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID)
+		err := rows.Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID, &comment.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -142,14 +159,17 @@ func (m *CommentModel) GetCommentsByUserID(userID int64) ([]*Comment, error) {
 }
 
 //сортировка по айди персонажа
-func (m *CommentModel) GetCommentsByCharacter() ([]*Comment, error) {
+func (m *CommentModel) GetCommentsByCharacter(ctx context.Context) ([]*Comment, error) {
 
     query := `
-        SELECT Id, UsernameID, Comment, CharacterID
+        SELECT Id, UsernameID, Comment, CharacterID, CreatedAt
         FROM comments
         ORDER BY CharacterID 
     `
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, span := startSpan(ctx, "CommentModel.GetCommentsByCharacter")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
     defer cancel()
 
     rows, err := m.DB.QueryContext(ctx, query)
@@ -161,7 +181,7 @@ func (m *CommentModel) GetCommentsByCharacter() ([]*Comment, error) {
     var comments []*Comment
     for rows.Next() {
         comment := &Comment{}
-        err := rows.Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID)
+        err := rows.Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID, &comment.CreatedAt)
         if err != nil {
             return nil, err
         }
@@ -176,14 +196,17 @@ func (m *CommentModel) GetCommentsByCharacter() ([]*Comment, error) {
 }
 
 //пагинация
-func (m *CommentModel) GetCommentsPagination(limit, offset int) ([]*Comment, error) {
+func (m *CommentModel) GetCommentsPagination(ctx context.Context, limit, offset int) ([]*Comment, error) {
     
     query := `
-        SELECT Id, UsernameID, Comment, CharacterID
+        SELECT Id, UsernameID, Comment, CharacterID, CreatedAt
         FROM comments
         LIMIT $1 OFFSET $2
     `
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, span := startSpan(ctx, "CommentModel.GetCommentsPagination")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
     defer cancel()
 
     rows, err := m.DB.QueryContext(ctx, query, limit, offset)
@@ -195,7 +218,7 @@ func (m *CommentModel) GetCommentsPagination(limit, offset int) ([]*Comment, err
     var comments []*Comment
     for rows.Next() {
         comment := &Comment{}
-        err := rows.Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID)
+        err := rows.Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID, &comment.CreatedAt)
         if err != nil {
             return nil, err
         }
@@ -209,14 +232,17 @@ func (m *CommentModel) GetCommentsPagination(limit, offset int) ([]*Comment, err
     return comments, nil
 }
 
-func (m *CommentModel) GetCommentsByCharacterID(characterID int) ([]*Comment, error) {
+func (m *CommentModel) GetCommentsByCharacterID(ctx context.Context, characterID int) ([]*Comment, error) {
 	query := `
-		SELECT Id, UsernameID, Comment, CharacterID
+		SELECT Id, UsernameID, Comment, CharacterID, CreatedAt
 		FROM comments
 		WHERE CharacterID = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, span := startSpan(ctx, "CommentModel.GetCommentsByCharacterID")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, characterID)
@@ -228,7 +254,7 @@ func (m *CommentModel) GetCommentsByCharacterID(characterID int) ([]*Comment, er
 	var comments []*Comment
 	for rows.Next() {
 		comment := &Comment{}
-		err := rows.Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID)
+		err := rows.Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID, &comment.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -243,14 +269,17 @@ func (m *CommentModel) GetCommentsByCharacterID(characterID int) ([]*Comment, er
 }
 
 // выводим список комментов от определенного юзера
-func (m *CommentModel) GetCommentsByUser(userID int) ([]*Comment, error) {
+func (m *CommentModel) GetCommentsByUser(ctx context.Context, userID int) ([]*Comment, error) {
 	query := `
-		SELECT Id, UsernameID, Comment, CharacterID
+		SELECT Id, UsernameID, Comment, CharacterID, CreatedAt
 		FROM comments
 		WHERE UsernameID = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, span := startSpan(ctx, "CommentModel.GetCommentsByUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
@@ -262,7 +291,7 @@ func (m *CommentModel) GetCommentsByUser(userID int) ([]*Comment, error) {
 	var comments []*Comment
 	for rows.Next() {
 		comment := &Comment{}
-		err := rows.Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID)
+		err := rows.Scan(&comment.Id, &comment.UsernameID, &comment.Comment, &comment.CharacterID, &comment.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,199 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Statuses of a data export.
+const (
+	ExportPending  = "pending"
+	ExportComplete = "complete"
+	ExportFailed   = "failed"
+)
+
+// exportStaleAfter is how long an export can stay pending before it is assumed to have been
+// lost, for example because the server was restarted while building it.
+const exportStaleAfter = time.Hour
+
+// ErrExportPending is returned when a user asks for a new export while one is being built.
+var ErrExportPending = errors.New("export pending")
+
+// Export is a user's request for an archive of their personal data. The archive itself is only
+// loaded by GetArchive.
+type Export struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"-"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+type ExportModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Timeouts Timeouts
+}
+
+// Start records a new pending export for a user, replacing any earlier one. It returns
+// ErrExportPending if an export is already being built.
+func (m ExportModel) Start(ctx context.Context, userID int64) (*Export, error) {
+	query := `
+		INSERT INTO data_exports (user_id)
+		VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE
+		SET status = $2, archive = NULL, error = '', created_at = NOW(), completed_at = NULL
+		WHERE data_exports.status <> $2 OR data_exports.created_at < $3
+		RETURNING id, status, created_at
+		`
+
+	export := Export{UserID: userID}
+
+	ctx, span := startSpan(ctx, "ExportModel.Start")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, ExportPending, time.Now().Add(-exportStaleAfter)).
+		Scan(&export.ID, &export.Status, &export.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrExportPending
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
+
+// Complete stores the finished archive of an export.
+func (m ExportModel) Complete(ctx context.Context, id int64, archive []byte) error {
+	query := `
+		UPDATE data_exports
+		SET status = $2, archive = $3, completed_at = NOW()
+		WHERE id = $1
+		`
+
+	ctx, span := startSpan(ctx, "ExportModel.Complete")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Batch)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, ExportComplete, archive)
+	return err
+}
+
+// Fail marks an export as failed. The message is shown to the user, so it should not contain
+// internal details.
+func (m ExportModel) Fail(ctx context.Context, id int64, message string) error {
+	query := `
+		UPDATE data_exports
+		SET status = $2, error = $3, completed_at = NOW()
+		WHERE id = $1
+		`
+
+	ctx, span := startSpan(ctx, "ExportModel.Fail")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, ExportFailed, message)
+	return err
+}
+
+// GetForUser returns a user's export without its archive, or gorm.ErrRecordNotFound if they
+// have never asked for one.
+func (m ExportModel) GetForUser(ctx context.Context, userID int64) (*Export, error) {
+	query := `
+		SELECT id, status, error, created_at, completed_at
+		FROM data_exports
+		WHERE user_id = $1
+		`
+
+	export := Export{UserID: userID}
+
+	ctx, span := startSpan(ctx, "ExportModel.GetForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).
+		Scan(&export.ID, &export.Status, &export.Error, &export.CreatedAt, &export.CompletedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, gorm.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
+
+// GetArchive returns the archive of a user's completed export, or gorm.ErrRecordNotFound if
+// there is none.
+func (m ExportModel) GetArchive(ctx context.Context, userID int64) ([]byte, *Export, error) {
+	query := `
+		SELECT id, status, created_at, completed_at, archive
+		FROM data_exports
+		WHERE user_id = $1 AND status = $2
+		`
+
+	export := Export{UserID: userID}
+	var archive []byte
+
+	ctx, span := startSpan(ctx, "ExportModel.GetArchive")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Batch)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, ExportComplete).
+		Scan(&export.ID, &export.Status, &export.CreatedAt, &export.CompletedAt, &archive)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, gorm.ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return archive, &export, nil
+}
+
+// DeleteFinishedBefore deletes the exports which were completed or failed before the given
+// time, and returns how many there were.
+func (m ExportModel) DeleteFinishedBefore(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM data_exports
+		WHERE completed_at < $1
+		`
+
+	ctx, span := startSpan(ctx, "ExportModel.DeleteFinishedBefore")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Batch)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
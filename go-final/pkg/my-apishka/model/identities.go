@@ -0,0 +1,114 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IdentityModel links users to accounts at external OpenID Connect providers. An identity is
+// the provider's name together with the "sub" claim the provider uses for the account.
+// Identity is an external account linked to a user.
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IdentityModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Timeouts Timeouts
+}
+
+// GetUser returns the user linked to an external identity, or gorm.ErrRecordNotFound if the
+// identity hasn't been linked yet.
+func (m IdentityModel) GetUser(ctx context.Context, provider, subject string) (*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		INNER JOIN user_identities ON user_identities.user_id = users.ID
+		WHERE user_identities.provider = $1 AND user_identities.subject = $2
+		`
+
+	var user User
+
+	ctx, span := startSpan(ctx, "IdentityModel.GetUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(user.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, gorm.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// Link links an external identity to a user.
+func (m IdentityModel) Link(ctx context.Context, provider, subject string, userID int64, email string) error {
+	query := `
+		INSERT INTO user_identities (provider, subject, user_id, email)
+		VALUES ($1, $2, $3, $4)
+		`
+
+	ctx, span := startSpan(ctx, "IdentityModel.Link")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, provider, subject, userID, email)
+	return err
+}
+
+// GetAllForUser returns the external identities linked to a user.
+func (m IdentityModel) GetAllForUser(ctx context.Context, userID int64) ([]*Identity, error) {
+	query := `
+		SELECT provider, subject, email, created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+		`
+
+	ctx, span := startSpan(ctx, "IdentityModel.GetAllForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
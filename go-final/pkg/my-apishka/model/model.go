@@ -4,19 +4,34 @@ import (
 	"database/sql"
 	"log"
 	"os"
+	"time"
 )
 
 
 type Models struct {
 	Characters CharacterModel
-	Users UserModel 
+	Users UserModel
 	Tokens TokenModel
 	Permissions PermissionModel
 	Comments CommentModel
+	TwoFactor TwoFactorModel
+	APIKeys APIKeyModel
+	Identities IdentityModel
+	Exports ExportModel
+}
+
+// Timeouts bound how long a single query may run. The context passed in by the caller still
+// applies, so a query ends at whichever deadline comes first, or when the request is cancelled.
+type Timeouts struct {
+	// Query applies to the queries behind requests.
+	Query time.Duration
+	// Batch applies to the bulk deletes of the maintenance jobs, and to storing and loading
+	// export archives.
+	Batch time.Duration
 }
 
 
-func NewModels(db *sql.DB) Models {
+func NewModels(db *sql.DB, timeouts Timeouts) Models {
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 	return Models{
@@ -24,18 +39,39 @@ func NewModels(db *sql.DB) Models {
 			DB:       db,
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
+			Timeouts: timeouts,
 		},
 		Users: UserModel{
-			DB: db,
+			DB:       db,
+			Timeouts: timeouts,
 		},
 		Tokens: TokenModel{
-			DB: db,
+			DB:       db,
+			Timeouts: timeouts,
 		},
 		Permissions: PermissionModel{
-			DB: db,
+			DB:       db,
+			Timeouts: timeouts,
 		},
 		Comments: CommentModel{
-			DB: db,
+			DB:       db,
+			Timeouts: timeouts,
+		},
+		TwoFactor: TwoFactorModel{
+			DB:       db,
+			Timeouts: timeouts,
+		},
+		APIKeys: APIKeyModel{
+			DB:       db,
+			Timeouts: timeouts,
+		},
+		Identities: IdentityModel{
+			DB:       db,
+			Timeouts: timeouts,
+		},
+		Exports: ExportModel{
+			DB:       db,
+			Timeouts: timeouts,
 		},
 	}
-}
\ No newline at end of file
+}
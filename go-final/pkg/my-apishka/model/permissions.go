@@ -3,19 +3,27 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
-	"time"
+	"strings"
 
-	// "github.com/lib/pq"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
 )
 
 // Permissions holds the permission codes for a single user.
 type Permissions []string
 
-// Include checks whether the Permissions slice contains a specific permission code.
+// Include checks whether the Permissions slice contains a specific permission code. A code
+// ending in ":*" grants everything under its prefix, so "characters:*" includes
+// "characters:write", and the code "*" includes every permission.
 func (p Permissions) Include(code string) bool {
 	for i := range p {
-		if code == p[i] {
+		if code == p[i] || p[i] == "*" {
+			return true
+		}
+
+		if prefix, ok := strings.CutSuffix(p[i], "*"); ok && strings.HasSuffix(prefix, ":") && strings.HasPrefix(code, prefix) {
 			return true
 		}
 	}
@@ -23,36 +31,71 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+// Role is a named bundle of permission codes which can be granted to users.
+type Role struct {
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
 type PermissionModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	Timeouts Timeouts
+}
+
+// GetAllForUser returns all permission codes for a specific user in a Permissions slice. These
+// are the permissions granted to the user directly together with those of the user's roles.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+			INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+			INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1
+		`
+
+	return m.queryStrings(ctx, "PermissionModel.GetAllForUser", query, userID)
 }
 
-// GetAllForUser returns all permission codes for a specific user in a Permissions slice.
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetDirectForUser returns only the permission codes granted to a user directly.
+func (m PermissionModel) GetDirectForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
 			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-			INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	return m.queryStrings(ctx, "PermissionModel.GetDirectForUser", query, userID)
+}
+
+// GetAll returns every permission code known to the database.
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	return m.queryStrings(ctx, "PermissionModel.GetAll", `SELECT code FROM permissions ORDER BY code`)
+}
+
+// queryStrings runs a query returning a single text column, in a span with the given name.
+func (m PermissionModel) queryStrings(ctx context.Context, name, query string, args ...interface{}) (Permissions, error) {
+	ctx, span := startSpan(ctx, name)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			m.ErrorLog.Println(err)
-		}
-	}()
+	defer rows.Close()
 
-	var permissions Permissions
+	permissions := Permissions{}
 
 	for rows.Next() {
 		var permission string
@@ -70,4 +113,204 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	}
 
 	return permissions, nil
-}
\ No newline at end of file
+}
+
+// AddForUser grants permission codes to a user directly. Codes the user already has, and codes
+// which don't exist, are skipped.
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions (user_id, permission_id)
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
+		`
+
+	ctx, span := startSpan(ctx, "PermissionModel.AddForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// RemoveForUser revokes a permission code granted to a user directly. It returns
+// gorm.ErrRecordNotFound if the user didn't have it.
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, code string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1 AND permissions.code = $2
+		`
+
+	return m.execOne(ctx, "PermissionModel.RemoveForUser", query, userID, code)
+}
+
+// GetRoles returns all roles with the permission codes they bundle.
+func (m PermissionModel) GetRoles(ctx context.Context) ([]*Role, error) {
+	query := `
+		SELECT roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.code)
+			FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+			LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+			LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		GROUP BY roles.id, roles.name
+		ORDER BY roles.id
+		`
+
+	ctx, span := startSpan(ctx, "PermissionModel.GetRoles")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		var codes []string
+
+		err := rows.Scan(&role.Name, pq.Array(&codes))
+		if err != nil {
+			return nil, err
+		}
+
+		role.Permissions = codes
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetRolesForUser returns the names of the roles granted to a user.
+func (m PermissionModel) GetRolesForUser(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+		SELECT roles.name
+		FROM roles
+			INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.id
+		`
+
+	return m.queryStrings(ctx, "PermissionModel.GetRolesForUser", query, userID)
+}
+
+// AddRoleForUser grants a role to a user. It returns gorm.ErrRecordNotFound if there is no role
+// with that name.
+func (m PermissionModel) AddRoleForUser(ctx context.Context, userID int64, role string) error {
+	ctx, span := startSpan(ctx, "PermissionModel.AddRoleForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	var roleID int64
+	err := m.DB.QueryRowContext(ctx, `SELECT id FROM roles WHERE name = $1`, role).Scan(&roleID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return gorm.ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO users_roles (user_id, role_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+		`
+
+	_, err = m.DB.ExecContext(ctx, query, userID, roleID)
+	return err
+}
+
+// RemoveRoleForUser revokes a role from a user. It returns gorm.ErrRecordNotFound if the user
+// didn't have it.
+func (m PermissionModel) RemoveRoleForUser(ctx context.Context, userID int64, role string) error {
+	query := `
+		DELETE FROM users_roles
+		USING roles
+		WHERE users_roles.role_id = roles.id
+		AND users_roles.user_id = $1 AND roles.name = $2
+		`
+
+	return m.execOne(ctx, "PermissionModel.RemoveRoleForUser", query, userID, role)
+}
+
+// execOne runs a statement, in a span with the given name, which is expected to affect a row,
+// and returns gorm.ErrRecordNotFound if it didn't.
+func (m PermissionModel) execOne(ctx context.Context, name, query string, args ...interface{}) error {
+	ctx, span := startSpan(ctx, name)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetHousesForUser returns the houses a user has been assigned to.
+func (m PermissionModel) GetHousesForUser(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+		SELECT house
+		FROM users_houses
+		WHERE user_id = $1
+		ORDER BY house
+		`
+
+	return m.queryStrings(ctx, "PermissionModel.GetHousesForUser", query, userID)
+}
+
+// AddHouseForUser assigns a house to a user.
+func (m PermissionModel) AddHouseForUser(ctx context.Context, userID int64, house string) error {
+	query := `
+		INSERT INTO users_houses (user_id, house)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+		`
+
+	ctx, span := startSpan(ctx, "PermissionModel.AddHouseForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, house)
+	return err
+}
+
+// RemoveHouseForUser removes a house assignment. It returns gorm.ErrRecordNotFound if the user
+// wasn't assigned to the house.
+func (m PermissionModel) RemoveHouseForUser(ctx context.Context, userID int64, house string) error {
+	query := `
+		DELETE FROM users_houses
+		WHERE user_id = $1 AND house = $2
+		`
+
+	return m.execOne(ctx, "PermissionModel.RemoveHouseForUser", query, userID, house)
+}
@@ -0,0 +1,98 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingConnector opens connections whose queries never finish on their own. Like lib/pq,
+// they give up with the context's error once it is done.
+type blockingConnector struct{}
+
+func (blockingConnector) Connect(context.Context) (driver.Conn, error) { return blockingConn{}, nil }
+func (blockingConnector) Driver() driver.Driver                        { return blockingDriver{} }
+
+type blockingDriver struct{}
+
+func (blockingDriver) Open(string) (driver.Conn, error) { return blockingConn{}, nil }
+
+type blockingConn struct{}
+
+func (blockingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (blockingConn) Close() error                        { return nil }
+func (blockingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (blockingConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingConn) ExecContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestQueriesHonourContext(t *testing.T) {
+	db := sql.OpenDB(blockingConnector{})
+	defer db.Close()
+
+	queries := map[string]func(m Models, ctx context.Context) error{
+		"UserModel.GetByEmail": func(m Models, ctx context.Context) error {
+			_, err := m.Users.GetByEmail(ctx, "harry@hogwarts.example")
+			return err
+		},
+		"APIKeyModel.GetForKey": func(m Models, ctx context.Context) error {
+			_, _, err := m.APIKeys.GetForKey(ctx, "hpk_abcdefgh_secret")
+			return err
+		},
+		"TwoFactorModel.Get": func(m Models, ctx context.Context) error {
+			_, err := m.TwoFactor.Get(ctx, 1)
+			return err
+		},
+		"IdentityModel.GetUser": func(m Models, ctx context.Context) error {
+			_, err := m.Identities.GetUser(ctx, "hogwarts", "subject")
+			return err
+		},
+		"ExportModel.GetArchive": func(m Models, ctx context.Context) error {
+			_, _, err := m.Exports.GetArchive(ctx, 1)
+			return err
+		},
+		"TokenModel.DeleteExpired": func(m Models, ctx context.Context) error {
+			_, err := m.Tokens.DeleteExpired(ctx, time.Now())
+			return err
+		},
+	}
+
+	for name, query := range queries {
+		t.Run(name+"/cancelled", func(t *testing.T) {
+			m := NewModels(db, Timeouts{Query: time.Minute, Batch: time.Minute})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+
+			start := time.Now()
+			err := query(m, ctx)
+
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got error %v; want context.Canceled", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("query returned after %s; want it aborted with the request", elapsed)
+			}
+		})
+
+		t.Run(name+"/timeout", func(t *testing.T) {
+			m := NewModels(db, Timeouts{Query: 20 * time.Millisecond, Batch: 20 * time.Millisecond})
+
+			err := query(m, context.Background())
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("got error %v; want context.DeadlineExceeded", err)
+			}
+		})
+	}
+}
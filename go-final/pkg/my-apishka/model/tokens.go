@@ -4,20 +4,33 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"errors"
 	"log"
 	"time"
 
 	"context"
 	"database/sql"
 	"go-final/pkg/my-apishka/validator"
+
+	"gorm.io/gorm"
 )
 
 
 const (
 	ScopeActivation = "activation"
 	ScopeAuthentication = "authentication" // Include a new authentication scope.
+	ScopeRefresh = "refresh"
+	ScopePasswordReset = "password-reset"
+	ScopeEmailChange = "email-change"
+	ScopeExportDownload = "export-download"
 	)
 
+var (
+	// ErrTokenReused is returned when a refresh token which has already been exchanged is
+	// presented again. This means the token has leaked, so its whole family is revoked.
+	ErrTokenReused = errors.New("token reused")
+)
+
 
 type (
 	// Token represents a token record in our tokens table.
@@ -28,12 +41,29 @@ type (
 		UserID    int64     `json:"-"`
 		Expiry    time.Time `json:"expiry"`
 		Scope     string    `json:"-"`
+		UserAgent string    `json:"-"`
+		IP        string    `json:"-"`
+		// Family links an access token and the chain of refresh tokens that were issued
+		// for the same login. Revoking a session revokes the whole family.
+		Family []byte `json:"-"`
+	}
+
+	// Session describes an authentication token as it is shown to its owner. It never exposes
+	// the token itself, only the metadata needed to recognise where the user is logged in.
+	Session struct {
+		ID         int64      `json:"id"`
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		Expiry     time.Time  `json:"expiry"`
+		UserAgent  string     `json:"user_agent"`
+		IP         string     `json:"ip"`
 	}
 
 	TokenModel struct {
 		DB       *sql.DB
 		InfoLog  *log.Logger
 		ErrorLog *log.Logger
+		Timeouts Timeouts
 	}
 )
 
@@ -86,43 +116,460 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 // New creates a new token and inserts the token record into the tokens table.
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 
 }
 
+// NewPair creates a short-lived access token and a long-lived refresh token in the same family
+// and inserts both in a single transaction. If family is nil a new family is started, which
+// is what happens on login; a refresh passes the family of the token it is exchanging.
+func (m TokenModel) NewPair(ctx context.Context, userID int64, accessTTL, refreshTTL time.Duration, family []byte, userAgent, ip string) (*Token, *Token, error) {
+	if family == nil {
+		family = make([]byte, 16)
+		if _, err := rand.Read(family); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.Family = family
+		token.UserAgent = userAgent
+		token.IP = ip
+	}
+
+	ctx, span := startSpan(ctx, "TokenModel.NewPair")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	for _, token := range []*Token{access, refresh} {
+		if err := insertToken(ctx, tx, token); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// NewRefresh creates and inserts only the refresh token of a family. It is used when access
+// tokens are stateless and never stored in the tokens table.
+func (m TokenModel) NewRefresh(ctx context.Context, userID int64, ttl time.Duration, family []byte, userAgent, ip string) (*Token, error) {
+	if family == nil {
+		family = make([]byte, 16)
+		if _, err := rand.Read(family); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := generateToken(userID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = family
+	token.UserAgent = userAgent
+	token.IP = ip
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
 // Insert inserts a new token record into the tokens table.
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	ctx, span := startSpan(ctx, "TokenModel.Insert")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertToken(ctx context.Context, db execer, token *Token) error {
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip, family)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP, token.Family}
+
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ConsumeRefresh marks a refresh token as used and returns it, so that a new pair can be issued
+// in its family. A refresh token can only be consumed once: if it has already been used, the
+// whole family is deleted and ErrTokenReused is returned. An unknown or expired token results
+// in gorm.ErrRecordNotFound.
+func (m TokenModel) ConsumeRefresh(ctx context.Context, tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	// The used_at IS NULL condition makes the update the single point where a refresh token
+	// can be exchanged, so two concurrent requests with the same token can't both succeed.
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)
+		UPDATE tokens
+		SET used_at = NOW()
+		WHERE hash = $1 AND scope = $2 AND used_at IS NULL AND expiry > $3
+		RETURNING user_id, expiry, family, user_agent, ip
 		`
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	token := Token{
+		Plaintext: tokenPlaintext,
+		Hash:      tokenHash[:],
+		Scope:     ScopeRefresh,
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "TokenModel.ConsumeRefresh")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh, time.Now()).Scan(
+		&token.UserID,
+		&token.Expiry,
+		&token.Family,
+		&token.UserAgent,
+		&token.IP,
+	)
+	if err == nil {
+		return &token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// Nothing was updated. Find out whether this is a refresh token that was already used, in
+	// which case somebody is replaying it and the family must be revoked.
+	query = `
+		SELECT family
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND used_at IS NOT NULL
+		`
+
+	var family []byte
+	err = m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(&family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, gorm.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err := m.DeleteFamily(ctx, family); err != nil {
+		return nil, err
+	}
+
+	return nil, ErrTokenReused
+}
+
+// DeleteFamilyForToken deletes the given token together with the rest of its family.
+func (m TokenModel) DeleteFamilyForToken(ctx context.Context, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1
+			OR family = (SELECT family FROM tokens WHERE hash = $1)
+		`
+
+	ctx, span := startSpan(ctx, "TokenModel.DeleteFamilyForToken")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:])
+	return err
+}
+
+// DeleteFamily deletes every token, access and refresh, belonging to a token family.
+func (m TokenModel) DeleteFamily(ctx context.Context, family []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1
+		`
+
+	ctx, span := startSpan(ctx, "TokenModel.DeleteFamily")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, family)
 	return err
 }
 
 // DeleteAllForUser deletes all tokens for a specific user and scope.
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
 		WHERE scope = $1 AND user_id = $2
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "TokenModel.DeleteAllForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
-}
\ No newline at end of file
+}
+
+// DeleteExpired deletes the tokens of every scope which expired before the given time, and
+// returns how many there were.
+func (m TokenModel) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry < $1
+		`
+
+	ctx, span := startSpan(ctx, "TokenModel.DeleteExpired")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Batch)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// LastCreatedForUser returns when the newest token of a scope was issued to a user, or nil if
+// the user has none.
+func (m TokenModel) LastCreatedForUser(ctx context.Context, scope string, userID int64) (*time.Time, error) {
+	query := `
+		SELECT MAX(created_at)
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2
+		`
+
+	var created *time.Time
+
+	ctx, span := startSpan(ctx, "TokenModel.LastCreatedForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, scope, userID).Scan(&created)
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// Touch records that the token was just used by the client with the given user agent and IP
+// address. The other tokens of the same family are updated too, so the session stays accurate
+// after its access token has been rotated.
+func (m TokenModel) Touch(ctx context.Context, tokenPlaintext, userAgent, ip string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE tokens
+		SET last_used_at = NOW(), user_agent = $2, ip = $3
+		WHERE hash = $1
+			OR family = (SELECT family FROM tokens WHERE hash = $1)
+		`
+
+	ctx, span := startSpan(ctx, "TokenModel.Touch")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], userAgent, ip)
+	return err
+}
+
+// GetSessionsForUser returns the sessions of a user, most recently used first. A session is
+// represented by the current (unused and unexpired) refresh token of its family.
+func (m TokenModel) GetSessionsForUser(ctx context.Context, userID int64) ([]*Session, error) {
+	query := `
+		SELECT id, created_at, last_used_at, expiry, user_agent, ip
+		FROM tokens
+		WHERE user_id = $1 AND scope = $2 AND used_at IS NULL AND expiry > $3
+		ORDER BY COALESCE(last_used_at, created_at) DESC
+		`
+
+	ctx, span := startSpan(ctx, "TokenModel.GetSessionsForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeRefresh, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt, &session.Expiry,
+			&session.UserAgent, &session.IP)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSessionForUser deletes every token of the session identified by the ID of its refresh
+// token. It returns gorm.ErrRecordNotFound if the user has no such session.
+func (m TokenModel) DeleteSessionForUser(ctx context.Context, id, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $2
+			AND family = (SELECT family FROM tokens WHERE id = $1 AND user_id = $2 AND scope = $3)
+		`
+
+	ctx, span := startSpan(ctx, "TokenModel.DeleteSessionForUser")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RevokeJWT records that the JWT with the given ID was revoked before its expiry. Inserting the
+// row announces the revocation to every API instance, see migration 20240310121400.
+func (m TokenModel) RevokeJWT(ctx context.Context, id string, expiry time.Time) error {
+	query := `
+		INSERT INTO revoked_jwts (id, expiry)
+		VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING
+		`
+
+	ctx, span := startSpan(ctx, "TokenModel.RevokeJWT")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, expiry)
+	return err
+}
+
+// GetRevokedJWTs returns the IDs and expiry times of the revoked JWTs which haven't expired yet.
+func (m TokenModel) GetRevokedJWTs(ctx context.Context) (map[string]time.Time, error) {
+	query := `
+		SELECT id, expiry
+		FROM revoked_jwts
+		WHERE expiry > $1
+		`
+
+	ctx, span := startSpan(ctx, "TokenModel.GetRevokedJWTs")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			id     string
+			expiry time.Time
+		)
+		if err := rows.Scan(&id, &expiry); err != nil {
+			return nil, err
+		}
+		revoked[id] = expiry
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revoked, nil
+}
+
+// DeleteExpiredRevocations deletes the revocations of JWTs which expired before the given time,
+// and returns how many there were.
+func (m TokenModel) DeleteExpiredRevocations(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM revoked_jwts
+		WHERE expiry < $1
+		`
+
+	ctx, span := startSpan(ctx, "TokenModel.DeleteExpiredRevocations")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Batch)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
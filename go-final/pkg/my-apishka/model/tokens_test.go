@@ -0,0 +1,79 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/gorm"
+)
+
+func TestConsumeRefresh(t *testing.T) {
+	const plaintext = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	hash := sha256.Sum256([]byte(plaintext))
+	family := []byte("family-of-tokens")
+
+	tests := []struct {
+		name    string
+		expect  func(mock sqlmock.Sqlmock)
+		wantErr error
+	}{
+		{
+			name: "first use",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("UPDATE tokens").WithArgs(hash[:], ScopeRefresh, sqlmock.AnyArg()).WillReturnRows(
+					sqlmock.NewRows([]string{"user_id", "expiry", "family", "user_agent", "ip"}).
+						AddRow(int64(1), time.Now().Add(time.Hour), family, "curl", "192.0.2.1"))
+			},
+		},
+		{
+			// Only the first use marks the token as used; a replay revokes the session.
+			name: "second use",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("UPDATE tokens").WithArgs(hash[:], ScopeRefresh, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "expiry", "family", "user_agent", "ip"}))
+				mock.ExpectQuery("used_at IS NOT NULL").WithArgs(hash[:], ScopeRefresh).
+					WillReturnRows(sqlmock.NewRows([]string{"family"}).AddRow(family))
+				mock.ExpectExec("DELETE FROM tokens").WithArgs(family).WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+			wantErr: ErrTokenReused,
+		},
+		{
+			name: "unknown or expired token",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("UPDATE tokens").
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "expiry", "family", "user_agent", "ip"}))
+				mock.ExpectQuery("used_at IS NOT NULL").WillReturnRows(sqlmock.NewRows([]string{"family"}))
+			},
+			wantErr: gorm.ErrRecordNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			tt.expect(mock)
+
+			m := TokenModel{DB: db, Timeouts: Timeouts{Query: time.Minute}}
+			token, err := m.ConsumeRefresh(context.Background(), plaintext)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (token.UserID != 1 || string(token.Family) != string(family)) {
+				t.Errorf("got user %d in family %q; want user 1 in family %q", token.UserID, token.Family, family)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
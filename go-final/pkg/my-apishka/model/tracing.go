@@ -0,0 +1,22 @@
+package model
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is resolved through the global provider, so spans are only exported once the
+// application has installed one.
+var tracer = otel.Tracer("go-final/pkg/my-apishka/model")
+
+// startSpan starts the span of a model method, named after its type and method such as
+// "UserModel.GetByEmail", as a child of the span in ctx.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpansAreChildrenOfTheRequest(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	db := sql.OpenDB(blockingConnector{})
+	defer db.Close()
+
+	m := NewModels(db, Timeouts{Query: 10 * time.Millisecond, Batch: 10 * time.Millisecond})
+
+	ctx, request := provider.Tracer("test").Start(context.Background(), "GET /api/v1/users/me/api-keys")
+
+	m.APIKeys.GetAllForUser(ctx, 1)
+	m.TwoFactor.Get(ctx, 1)
+	m.Identities.GetAllForUser(ctx, 1)
+	m.Exports.GetForUser(ctx, 1)
+
+	request.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 5 {
+		t.Fatalf("got %d spans; want 5", len(spans))
+	}
+
+	for _, span := range spans[:4] {
+		if span.Parent().SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("span %s has parent %s; want the request span %s", span.Name(),
+				span.Parent().SpanID(), request.SpanContext().SpanID())
+		}
+		if span.SpanContext().TraceID() != request.SpanContext().TraceID() {
+			t.Errorf("span %s is in trace %s; want the request's trace", span.Name(), span.SpanContext().TraceID())
+		}
+	}
+}
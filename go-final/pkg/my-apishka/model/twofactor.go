@@ -0,0 +1,263 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ScopeMFAPending is the scope of the short-lived token handed out after a correct password
+// when the user still has to provide a second factor.
+const ScopeMFAPending = "mfa-pending"
+
+var (
+	ErrTwoFactorEnabled = errors.New("two-factor authentication already enabled")
+)
+
+// TwoFactor is the TOTP enrolment of a user. It only takes effect once Confirmed is true.
+type TwoFactor struct {
+	UserID    int64
+	Secret    string
+	Confirmed bool
+	LastStep  int64
+}
+
+type TwoFactorModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Timeouts Timeouts
+}
+
+// Get returns the TOTP enrolment of a user, or gorm.ErrRecordNotFound if there is none.
+func (m TwoFactorModel) Get(ctx context.Context, userID int64) (*TwoFactor, error) {
+	query := `
+		SELECT user_id, secret, confirmed, last_step
+		FROM users_totp
+		WHERE user_id = $1
+		`
+
+	var tf TwoFactor
+
+	ctx, span := startSpan(ctx, "TwoFactorModel.Get")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&tf.UserID, &tf.Secret, &tf.Confirmed, &tf.LastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, gorm.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tf, nil
+}
+
+// Enabled reports whether the user has confirmed TOTP enrolment.
+func (m TwoFactorModel) Enabled(ctx context.Context, userID int64) (bool, error) {
+	tf, err := m.Get(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return tf.Confirmed, nil
+}
+
+// Enrol stores a new, unconfirmed secret for the user, replacing any earlier unconfirmed one.
+// It returns ErrTwoFactorEnabled if the user has already confirmed an enrolment.
+func (m TwoFactorModel) Enrol(ctx context.Context, userID int64, secret string) error {
+	query := `
+		INSERT INTO users_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()
+			WHERE users_totp.confirmed = false
+		`
+
+	ctx, span := startSpan(ctx, "TwoFactorModel.Enrol")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	return nil
+}
+
+// Confirm enables two-factor authentication for the user.
+func (m TwoFactorModel) Confirm(ctx context.Context, userID int64) error {
+	query := `
+		UPDATE users_totp
+		SET confirmed = true
+		WHERE user_id = $1
+		`
+
+	ctx, span := startSpan(ctx, "TwoFactorModel.Confirm")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// UseStep records that the code for the given time step has been used. It returns false if a
+// code for this or a later step was used already, which stops the same code from being
+// replayed, including by two concurrent requests.
+func (m TwoFactorModel) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	query := `
+		UPDATE users_totp
+		SET last_step = $2
+		WHERE user_id = $1 AND last_step < $2
+		`
+
+	ctx, span := startSpan(ctx, "TwoFactorModel.UseStep")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// Disable removes the TOTP enrolment and the recovery codes of the user.
+func (m TwoFactorModel) Disable(ctx context.Context, userID int64) error {
+	ctx, span := startSpan(ctx, "TwoFactorModel.Disable")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// NewRecoveryCodes replaces the recovery codes of the user with n fresh ones and returns their
+// plaintext. Only the SHA-256 hashes are stored, so the codes can't be shown again.
+func (m TwoFactorModel) NewRecoveryCodes(ctx context.Context, userID int64, n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		// Sixteen base-32 characters, shown as two groups of eight for readability.
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+		codes[i] = code[:8] + "-" + code[8:]
+	}
+
+	ctx, span := startSpan(ctx, "TwoFactorModel.NewRecoveryCodes")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		hash := sha256.Sum256([]byte(code))
+		_, err := tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks a recovery code of the user as used. It returns false if the code
+// doesn't exist or has been used before.
+func (m TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL
+		`
+
+	ctx, span := startSpan(ctx, "TwoFactorModel.UseRecoveryCode")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
@@ -3,6 +3,9 @@ package model
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	"go-final/pkg/my-apishka/validator"
@@ -24,6 +27,76 @@ type User struct {
 	Password   password  `json:"-"`
 	Activated  bool      `json:"Activated"`
 	Version    int       `json:"-"`
+	// SuspendedAt is set while an admin has suspended the account.
+	SuspendedAt *time.Time `json:"SuspendedAt,omitempty"`
+	// PasswordResetRequired is set when an admin forces the user to choose a new password.
+	PasswordResetRequired bool `json:"PasswordResetRequired"`
+
+	Bio            string `json:"Bio"`
+	AvatarURL      string `json:"AvatarURL"`
+	FavouriteHouse string `json:"FavouriteHouse"`
+	// PendingEmail is the new address of an email change which hasn't been confirmed yet.
+	PendingEmail *string `json:"PendingEmail,omitempty"`
+	// DeletionScheduledAt is set when the user has asked for their account to be deleted.
+	DeletionScheduledAt *time.Time `json:"DeletionScheduledAt,omitempty"`
+}
+
+// Houses are the values allowed for User.FavouriteHouse, besides no house at all.
+var Houses = []string{"Gryffindor", "Hufflepuff", "Ravenclaw", "Slytherin"}
+
+// userColumns are the columns selected by every query that returns whole users, in the order
+// that scanFields expects them.
+const userColumns = `users.ID, users.CreatedAt, users.Username, users.Email, users.Password,
+	users.Activated, users.Version, users.SuspendedAt, users.PasswordResetRequired, users.Bio,
+	users.AvatarURL, users.FavouriteHouse, users.PendingEmail, users.DeletionScheduledAt`
+
+// scanFields returns the scan destinations for userColumns.
+func (u *User) scanFields() []interface{} {
+	return []interface{}{
+		&u.ID,
+		&u.CreatedAt,
+		&u.Username,
+		&u.Email,
+		&u.Password.hash,
+		&u.Activated,
+		&u.Version,
+		&u.SuspendedAt,
+		&u.PasswordResetRequired,
+		&u.Bio,
+		&u.AvatarURL,
+		&u.FavouriteHouse,
+		&u.PendingEmail,
+		&u.DeletionScheduledAt,
+	}
+}
+
+// IsSuspended reports whether the account is suspended.
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// Account statuses, as announced by Postgres when a user is suspended, scheduled for deletion,
+// forced to reset their password or deleted, and as returned by GetBlockedStatuses.
+const (
+	AccountActive        = "active"
+	AccountSuspended     = "suspended"
+	AccountDeleting      = "deleting"
+	AccountPasswordReset = "password-reset"
+	AccountDeleted       = "deleted"
+)
+
+// AccountStatus returns AccountSuspended, AccountDeleting, AccountPasswordReset or
+// AccountActive. The first that applies wins, as in the users_status_changed trigger.
+func (u *User) AccountStatus() string {
+	switch {
+	case u.SuspendedAt != nil:
+		return AccountSuspended
+	case u.DeletionScheduledAt != nil:
+		return AccountDeleting
+	case u.PasswordResetRequired:
+		return AccountPasswordReset
+	}
+	return AccountActive
 }
 
 type password struct {
@@ -75,10 +148,11 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 
 
 type UserModel struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Timeouts Timeouts
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 		INSERT INTO users (Username, Email, Password, Activated)
 		VALUES ($1, $2, $3, $4)
@@ -87,7 +161,10 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []interface{}{user.Username, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "UserModel.Insert")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	// If the table already contains a record with this email address, then when we try to
@@ -108,27 +185,22 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT ID, CreatedAt, Username, Email, Password, Activated, Version
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE Email = $1
 		`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "UserModel.GetByEmail")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(
-		&user.ID,
-		&user.CreatedAt,
-		&user.Username,
-		&user.Email,
-		&user.Password.hash,
-		&user.Activated,
-		&user.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(user.scanFields()...)
 
 	if err != nil {
 		switch {
@@ -142,11 +214,42 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE ID = $1
+		`
+
+	var user User
+
+	ctx, span := startSpan(ctx, "UserModel.Get")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(user.scanFields()...)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, gorm.ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 		UPDATE users
-		SET Username = $1, Email = $2, Password = $3, Activated = $4, Version = Version + 1
-		WHERE ID = $5 AND Version = $6
+		SET Username = $1, Email = $2, Password = $3, Activated = $4, SuspendedAt = $5,
+			PasswordResetRequired = $6, Bio = $7, AvatarURL = $8, FavouriteHouse = $9,
+			PendingEmail = $10, DeletionScheduledAt = $11, Version = Version + 1
+		WHERE ID = $12 AND Version = $13
 		RETURNING Version
 		`
 
@@ -155,11 +258,21 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.SuspendedAt,
+		user.PasswordResetRequired,
+		user.Bio,
+		user.AvatarURL,
+		user.FavouriteHouse,
+		user.PendingEmail,
+		user.DeletionScheduledAt,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "UserModel.Update")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -177,6 +290,136 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
+// Search returns a page of users whose email and username contain the given strings, optionally
+// restricted to activated or not yet activated users. Empty strings and a nil activated match
+// everyone.
+func (m UserModel) Search(ctx context.Context, email, username string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), `+userColumns+`
+		FROM users
+		WHERE ($1 = '' OR Email ILIKE '%%' || $1 || '%%' ESCAPE '\')
+		AND ($2 = '' OR Username ILIKE '%%' || $2 || '%%' ESCAPE '\')
+		AND ($3::bool IS NULL OR Activated = $3)
+		ORDER BY %s %s, ID ASC
+		LIMIT $4 OFFSET $5
+		`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{likeEscape(email), likeEscape(username), activated, filters.limit(), filters.offset()}
+
+	ctx, span := startSpan(ctx, "UserModel.Search")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(append([]interface{}{&totalRecords}, user.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return users, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// likeEscape escapes the wildcard characters of a LIKE pattern, so that user input only ever
+// matches literally.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Delete deletes a user along with everything that references it. Like Update it only succeeds
+// if the user hasn't changed since it was read, and returns ErrEditConflict otherwise.
+func (m UserModel) Delete(ctx context.Context, user *User) error {
+	query := `
+		DELETE FROM users
+		WHERE ID = $1 AND Version = $2
+		`
+
+	ctx, span := startSpan(ctx, "UserModel.Delete")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, user.ID, user.Version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// DeleteScheduled deletes the users whose deletion was scheduled for before the given time,
+// and returns how many there were.
+func (m UserModel) DeleteScheduled(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE DeletionScheduledAt <= $1
+		`
+
+	ctx, span := startSpan(ctx, "UserModel.DeleteScheduled")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Batch)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteUnactivated deletes the users who registered before the given time and never activated
+// their account, and returns how many there were.
+func (m UserModel) DeleteUnactivated(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE NOT Activated AND CreatedAt < $1
+		`
+
+	ctx, span := startSpan(ctx, "UserModel.DeleteUnactivated")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Batch)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be valid email address")
@@ -212,15 +455,28 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+// ValidateProfile checks the fields users fill in about themselves.
+func ValidateProfile(v *validator.Validator, user *User) {
+	v.Check(len(user.Bio) <= 1000, "bio", "must not be more than 1000 bytes long")
+
+	if user.AvatarURL != "" {
+		u, err := url.Parse(user.AvatarURL)
+		v.Check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "avatar_url", "must be an http or https URL")
+		v.Check(len(user.AvatarURL) <= 2048, "avatar_url", "must not be more than 2048 bytes long")
+	}
+
+	if user.FavouriteHouse != "" {
+		v.Check(validator.In(user.FavouriteHouse, Houses...), "favourite_house", "must be one of "+strings.Join(Houses, ", "))
+	}
+}
+
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash for the plaintext token provided by the client.
 	// Note, that this will return a byte *array* with length 32, not a slice.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-		SELECT 
-			users.ID, users.CreatedAt, users.Username, users.Email, 
-			users.Password, users.Activated, users.Version
+		SELECT ` + userColumns + `
 		FROM       users
         INNER JOIN tokens
 			ON users.id = tokens.user_id
@@ -239,20 +495,15 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "UserModel.GetForToken")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
 	defer cancel()
 
 	// Execute the query, scanning the return values into a User struct. If no matching record
 	// is found we return an ErrRecordNotFound error.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
-		&user.ID,
-		&user.CreatedAt,
-		&user.Username,
-		&user.Email,
-		&user.Password.hash,
-		&user.Activated,
-		&user.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(user.scanFields()...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -264,4 +515,47 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	// Return the matching user.
 	return &user, nil
-}
\ No newline at end of file
+}
+// GetBlockedStatuses returns the IDs of the users who are suspended, whose deletion is
+// scheduled or who must reset their password, with their status as in AccountStatus.
+func (m UserModel) GetBlockedStatuses(ctx context.Context) (map[int64]string, error) {
+	query := `
+		SELECT ID, CASE
+			WHEN SuspendedAt IS NOT NULL THEN $1
+			WHEN DeletionScheduledAt IS NOT NULL THEN $2
+			ELSE $3
+		END
+		FROM users
+		WHERE SuspendedAt IS NOT NULL OR DeletionScheduledAt IS NOT NULL OR PasswordResetRequired
+		`
+
+	ctx, span := startSpan(ctx, "UserModel.GetBlockedStatuses")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, AccountSuspended, AccountDeleting, AccountPasswordReset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[int64]string)
+	for rows.Next() {
+		var (
+			id     int64
+			status string
+		)
+		if err := rows.Scan(&id, &status); err != nil {
+			return nil, err
+		}
+		statuses[id] = status
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,141 @@
+// Package policy decides whether a subject may perform an action on a particular resource.
+//
+// Route level permission checks answer "may this user edit comments at all?". Policies answer
+// "may this user edit this comment?", using attributes of both sides such as who owns the
+// resource, when it was created, or which house it belongs to. A request is denied unless some
+// rule for the action allows it.
+package policy
+
+import (
+	"time"
+)
+
+// Subject is the user asking to perform an action.
+type Subject struct {
+	ID int64
+	// Has reports whether the subject holds a permission code. A nil Has holds nothing.
+	Has func(code string) bool
+	// Houses are the houses the subject has been assigned to.
+	Houses []string
+}
+
+func (s Subject) has(code string) bool {
+	return s.Has != nil && s.Has(code)
+}
+
+// Resource is the thing the action is performed on.
+type Resource struct {
+	Kind      string
+	ID        int64
+	OwnerID   int64
+	House     string
+	CreatedAt time.Time
+}
+
+// Condition is a single check a rule is built from.
+type Condition func(s Subject, r Resource, now time.Time) bool
+
+// Rule allows the listed actions when its condition holds.
+type Rule struct {
+	Name      string
+	Actions   []string
+	Condition Condition
+}
+
+func (rule Rule) covers(action string) bool {
+	for _, a := range rule.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Decision is the outcome of an authorization request. Rule names the rule which allowed the
+// action, and is empty if it was denied.
+type Decision struct {
+	Allowed bool
+	Action  string
+	Rule    string
+}
+
+// Engine evaluates rules. It is safe for concurrent use once created.
+type Engine struct {
+	rules []Rule
+	now   func() time.Time
+}
+
+// New returns an engine for the given rules.
+func New(rules ...Rule) *Engine {
+	return &Engine{rules: rules, now: time.Now}
+}
+
+// Authorize decides whether the subject may perform the action on the resource.
+func (e *Engine) Authorize(s Subject, action string, r Resource) Decision {
+	now := e.now()
+
+	for _, rule := range e.rules {
+		if rule.covers(action) && rule.Condition(s, r, now) {
+			return Decision{Allowed: true, Action: action, Rule: rule.Name}
+		}
+	}
+
+	return Decision{Allowed: false, Action: action}
+}
+
+// HasPermission holds if the subject has the permission code.
+func HasPermission(code string) Condition {
+	return func(s Subject, _ Resource, _ time.Time) bool {
+		return s.has(code)
+	}
+}
+
+// Owner holds if the subject owns the resource.
+func Owner() Condition {
+	return func(s Subject, r Resource, _ time.Time) bool {
+		return s.ID != 0 && s.ID == r.OwnerID
+	}
+}
+
+// CreatedWithin holds if the resource was created less than d ago.
+func CreatedWithin(d time.Duration) Condition {
+	return func(_ Subject, r Resource, now time.Time) bool {
+		return !r.CreatedAt.IsZero() && now.Sub(r.CreatedAt) < d
+	}
+}
+
+// AssignedHouse holds if the resource belongs to one of the subject's houses.
+func AssignedHouse() Condition {
+	return func(s Subject, r Resource, _ time.Time) bool {
+		for _, house := range s.Houses {
+			if house == r.House {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// All holds if every one of the conditions holds.
+func All(conditions ...Condition) Condition {
+	return func(s Subject, r Resource, now time.Time) bool {
+		for _, c := range conditions {
+			if !c(s, r, now) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any holds if at least one of the conditions holds.
+func Any(conditions ...Condition) Condition {
+	return func(s Subject, r Resource, now time.Time) bool {
+		for _, c := range conditions {
+			if c(s, r, now) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fallback uses a shared limiter, such as Postgres, and switches to a local one when the shared
+// limiter fails or is too slow. After a failure it stays on the local limiter for Cooldown
+// before trying the shared one again, so a struggling database isn't asked on every request.
+//
+// Local limits are per replica, so while falling back a client can get up to one limit per
+// replica. That is still far better than no limit at all, or than refusing every request.
+type Fallback struct {
+	Shared   Limiter
+	Local    Limiter
+	Cooldown time.Duration
+	// OnError is called with every error of the shared limiter. It may be nil.
+	OnError func(err error)
+
+	mu        sync.Mutex
+	failedAt  time.Time
+	fallbacks atomic.Uint64
+}
+
+func NewFallback(shared, local Limiter, cooldown time.Duration) *Fallback {
+	return &Fallback{Shared: shared, Local: local, Cooldown: cooldown}
+}
+
+func (f *Fallback) Allow(key string, limit Limit) (Result, error) {
+	if !f.Degraded() {
+		res, err := f.Shared.Allow(key, limit)
+		if err == nil {
+			return res, nil
+		}
+
+		f.mu.Lock()
+		f.failedAt = time.Now()
+		f.mu.Unlock()
+
+		if f.OnError != nil {
+			f.OnError(err)
+		}
+	}
+
+	f.fallbacks.Add(1)
+	return f.Local.Allow(key, limit)
+}
+
+// Degraded reports whether the local limiter is currently being used.
+func (f *Fallback) Degraded() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return !f.failedAt.IsZero() && time.Since(f.failedAt) < f.Cooldown
+}
+
+// Fallbacks returns how many requests have been decided by the local limiter.
+func (f *Fallback) Fallbacks() uint64 {
+	return f.fallbacks.Load()
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// stubLimiter counts its calls and answers them with err, or allows them.
+type stubLimiter struct {
+	err   error
+	calls int
+}
+
+func (s *stubLimiter) Allow(string, Limit) (Result, error) {
+	s.calls++
+	if s.err != nil {
+		return Result{}, s.err
+	}
+	return Result{Allowed: true, Limit: 1}, nil
+}
+
+var testLimit = Limit{Rate: 1, Burst: 1}
+
+func TestFallbackUsesShared(t *testing.T) {
+	shared, local := &stubLimiter{}, &stubLimiter{}
+	f := NewFallback(shared, local, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := f.Allow("k", testLimit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if shared.calls != 3 || local.calls != 0 {
+		t.Errorf("got %d shared and %d local calls; want 3 and 0", shared.calls, local.calls)
+	}
+	if f.Degraded() || f.Fallbacks() != 0 {
+		t.Errorf("got degraded %t with %d fallbacks; want neither", f.Degraded(), f.Fallbacks())
+	}
+}
+
+func TestFallbackOnError(t *testing.T) {
+	failure := errors.New("connection refused")
+	shared, local := &stubLimiter{err: failure}, &stubLimiter{}
+
+	var reported []error
+	f := NewFallback(shared, local, time.Minute)
+	f.OnError = func(err error) { reported = append(reported, err) }
+
+	res, err := f.Allow("k", testLimit)
+	if err != nil {
+		t.Fatalf("got error %v; want the local limiter's answer", err)
+	}
+	if !res.Allowed {
+		t.Error("request refused; want it decided by the local limiter")
+	}
+	if len(reported) != 1 || reported[0] != failure {
+		t.Errorf("got reported errors %v; want the shared limiter's error", reported)
+	}
+	if !f.Degraded() {
+		t.Error("not degraded after a failure")
+	}
+
+	// During the cooldown the shared limiter isn't asked.
+	f.Allow("k", testLimit)
+	f.Allow("k", testLimit)
+
+	if shared.calls != 1 {
+		t.Errorf("got %d shared calls during the cooldown; want 1", shared.calls)
+	}
+	if local.calls != 3 || f.Fallbacks() != 3 {
+		t.Errorf("got %d local calls and %d fallbacks; want 3", local.calls, f.Fallbacks())
+	}
+
+	// Once the cooldown is over, the shared limiter is tried again.
+	shared.err = nil
+	f.mu.Lock()
+	f.failedAt = time.Now().Add(-time.Minute - time.Second)
+	f.mu.Unlock()
+
+	if f.Degraded() {
+		t.Error("still degraded after the cooldown")
+	}
+
+	f.Allow("k", testLimit)
+
+	if shared.calls != 2 || local.calls != 3 {
+		t.Errorf("got %d shared and %d local calls after the cooldown; want 2 and 3", shared.calls, local.calls)
+	}
+}
+
+func TestFallbackOnTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// The database answers, but only after the limiter's timeout.
+	mock.ExpectQuery("INSERT INTO rate_limits").WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"current", "previous"}).AddRow(1, 0))
+
+	local := &stubLimiter{}
+	f := NewFallback(NewPostgres(db, 10*time.Millisecond), local, time.Minute)
+
+	var reported error
+	f.OnError = func(err error) { reported = err }
+
+	start := time.Now()
+	res, err := f.Allow("k", testLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("request took %s; want it decided soon after the timeout", elapsed)
+	}
+	if !res.Allowed || local.calls != 1 {
+		t.Errorf("got allowed %t with %d local calls; want the local limiter to decide", res.Allowed, local.calls)
+	}
+	if reported == nil {
+		t.Error("timeout not reported")
+	}
+	if !f.Degraded() {
+		t.Error("not degraded after a timeout")
+	}
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Memory is a token bucket limiter in process memory. Each key has a bucket holding up to Burst
+// tokens which refills at Rate tokens per second, and every request takes one token.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (m *Memory) Allow(key string, limit Limit) (Result, error) {
+	if limit.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+
+	now := m.now()
+	burst := float64(limit.Burst)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	res := Result{Limit: limit.Burst}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((burst - b.tokens) / limit.Rate)
+
+	return res, nil
+}
+
+// Cleanup forgets the buckets which haven't been used for longer than idle, and returns how
+// many there were. A bucket idle for long enough to refill completely is the same as no bucket,
+// so idle should be at least Burst/Rate of the slowest limit.
+func (m *Memory) Cleanup(idle time.Duration) int {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for key, b := range m.buckets {
+		if now.Sub(b.last) > idle {
+			delete(m.buckets, key)
+			n++
+		}
+	}
+
+	return n
+}
+
+// Len returns the number of buckets currently kept.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.buckets)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// Postgres is a sliding window limiter kept in the rate_limits table, so that all replicas of
+// the API share the same counts.
+//
+// A limit of Burst requests with Rate per second becomes a window of Burst/Rate seconds which
+// allows Burst requests. Requests are counted per fixed window, and the count of the previous
+// window is weighted by how much of it still overlaps the sliding window. Every request is
+// counted, including refused ones, so a client that keeps hammering stays limited.
+type Postgres struct {
+	DB *sql.DB
+	// Timeout bounds each query. Rate limiting runs on every request, so this should be short;
+	// combine Postgres with Fallback to keep serving when the database is slow.
+	Timeout time.Duration
+}
+
+func NewPostgres(db *sql.DB, timeout time.Duration) *Postgres {
+	return &Postgres{DB: db, Timeout: timeout}
+}
+
+func (p *Postgres) Allow(key string, limit Limit) (Result, error) {
+	if limit.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+
+	window := seconds(float64(limit.Burst) / limit.Rate)
+	if window < time.Second {
+		window = time.Second
+	}
+
+	now := time.Now()
+	start := now.Truncate(window)
+
+	// Counting and reading the previous window in one statement keeps it to one round trip, and
+	// the upsert makes the increment atomic across replicas.
+	query := `
+		WITH current AS (
+			INSERT INTO rate_limits (key, window_start, count, expires_at)
+			VALUES ($1, $2, 1, $4)
+			ON CONFLICT (key, window_start) DO UPDATE
+			SET count = rate_limits.count + 1
+			RETURNING count
+		)
+		SELECT current.count, COALESCE((
+			SELECT count FROM rate_limits WHERE key = $1 AND window_start = $3
+		), 0)
+		FROM current
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	var current, previous int
+	err := p.DB.QueryRowContext(ctx, query, key, start, start.Add(-window), start.Add(2*window)).
+		Scan(&current, &previous)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return slidingWindow(limit.Burst, window, now.Sub(start), current, previous), nil
+}
+
+// slidingWindow decides a request from the counts of the current and the previous window, the
+// current count including the request itself.
+func slidingWindow(max int, window, elapsed time.Duration, current, previous int) Result {
+	weight := 1 - float64(elapsed)/float64(window)
+	estimate := float64(previous)*weight + float64(current)
+
+	res := Result{
+		Allowed:   estimate <= float64(max),
+		Limit:     max,
+		Remaining: int(math.Max(0, float64(max)-estimate)),
+		Reset:     window - elapsed,
+	}
+
+	if !res.Allowed {
+		switch {
+		case current >= max || previous == 0:
+			// Only the next window can make room.
+			res.RetryAfter = window - elapsed
+		default:
+			// Wait until enough of the previous window has slid out.
+			need := estimate - float64(max)
+			res.RetryAfter = time.Duration(need / float64(previous) * float64(window))
+			if res.RetryAfter > window-elapsed {
+				res.RetryAfter = window - elapsed
+			}
+		}
+	}
+
+	return res
+}
+
+// Cleanup deletes the windows which no longer count towards any limit, and returns how many
+// there were.
+func (p *Postgres) Cleanup(ctx context.Context) (int64, error) {
+	result, err := p.DB.ExecContext(ctx, `DELETE FROM rate_limits WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	const window = 10 * time.Second
+
+	tests := []struct {
+		name           string
+		elapsed        time.Duration
+		current        int
+		previous       int
+		wantAllowed    bool
+		wantRemaining  int
+		wantRetryAfter time.Duration
+	}{
+		{"first request", 0, 1, 0, true, 4, 0},
+		{"last request of the window", 5 * time.Second, 5, 0, true, 0, 0},
+		{"over the limit without a previous window", 5 * time.Second, 6, 0, false, 0, 5 * time.Second},
+		// 4*0.5 + 2 = 4 requests in the sliding window.
+		{"previous window half slid out", 5 * time.Second, 2, 4, true, 1, 0},
+		// 4*0.5 + 4 = 6: one request too many, which slides out after a quarter of the window.
+		{"previous window still counts", 5 * time.Second, 4, 4, false, 0, 2500 * time.Millisecond},
+		// 10*0.9 + 3 = 12: seven of the previous window's requests have to slide out.
+		{"busy previous window", time.Second, 3, 10, false, 0, 7 * time.Second},
+		{"current window full", 2 * time.Second, 5, 1, false, 0, 8 * time.Second},
+		{"previous window slid out", window, 1, 100, true, 4, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := slidingWindow(5, window, tt.elapsed, tt.current, tt.previous)
+
+			if res.Allowed != tt.wantAllowed {
+				t.Errorf("got allowed %t; want %t", res.Allowed, tt.wantAllowed)
+			}
+			if res.Limit != 5 {
+				t.Errorf("got limit %d; want 5", res.Limit)
+			}
+			if res.Remaining != tt.wantRemaining {
+				t.Errorf("got remaining %d; want %d", res.Remaining, tt.wantRemaining)
+			}
+			if res.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("got retry after %s; want %s", res.RetryAfter, tt.wantRetryAfter)
+			}
+			if res.Reset != window-tt.elapsed {
+				t.Errorf("got reset %s; want %s", res.Reset, window-tt.elapsed)
+			}
+		})
+	}
+}
+
+// openTestDB connects to the database named by TEST_DSN, which needs the migrations applied.
+// Tests which use it are skipped if it isn't set.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestPostgresSharedBetweenReplicas(t *testing.T) {
+	// Two replicas, each with its own connection pool to the same database.
+	replicas := []*Postgres{
+		NewPostgres(openTestDB(t), time.Second),
+		NewPostgres(openTestDB(t), time.Second),
+	}
+
+	key := "test:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	limit := Limit{Rate: 5.0 / 60, Burst: 5}
+
+	for i := 0; i < 5; i++ {
+		res, err := replicas[i%2].Allow(key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Fatalf("request %d refused; want the first %d allowed", i+1, limit.Burst)
+		}
+	}
+
+	for i, p := range replicas {
+		res, err := p.Allow(key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed {
+			t.Errorf("replica %d allowed a request over the shared limit", i)
+		}
+		if res.RetryAfter <= 0 {
+			t.Errorf("replica %d: got retry after %s; want a wait", i, res.RetryAfter)
+		}
+	}
+
+	// Other keys have their own counts.
+	res, err := replicas[0].Allow(key+":other", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed {
+		t.Error("request for another key refused")
+	}
+}
@@ -0,0 +1,37 @@
+// Package ratelimit limits how often a key (a client IP, a user, the whole server, ...) may make
+// requests.
+package ratelimit
+
+import (
+	"time"
+)
+
+// Limit allows Rate requests per second on average, and bursts of up to Burst requests.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited reports whether the limit is switched off.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// Result is the outcome of a request against a limit.
+type Result struct {
+	Allowed bool
+	// Limit is the burst size, which is what clients can spend at once.
+	Limit int
+	// Remaining is how many further requests would be allowed right now.
+	Remaining int
+	// Reset is how long until the key is back to its full burst.
+	Reset time.Duration
+	// RetryAfter is how long until the next request will be allowed, if this one wasn't.
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether a request for a key is allowed. Implementations must be safe for
+// concurrent use.
+type Limiter interface {
+	Allow(key string, limit Limit) (Result, error)
+}
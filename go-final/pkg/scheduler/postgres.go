@@ -0,0 +1,98 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"hash/fnv"
+	"time"
+)
+
+// releaseTimeout limits the query which releases the advisory lock after a run. It gets a
+// context of its own, since the run's context may have been cancelled by then.
+const releaseTimeout = 10 * time.Second
+
+// PostgresLocker elects the replica for a run with a session-level advisory lock, held on a
+// connection set aside for the run; Postgres releases it by itself if the replica dies mid-run.
+// While holding the lock it records the slot in the scheduled_jobs table and commits straight
+// away, so a replica that comes late to a slot which has already been claimed skips it too,
+// even if the run fails or is cancelled.
+type PostgresLocker struct {
+	DB *sql.DB
+}
+
+func NewPostgresLocker(db *sql.DB) *PostgresLocker {
+	return &PostgresLocker{DB: db}
+}
+
+func (l *PostgresLocker) Claim(ctx context.Context, job string, slot time.Time) (func() error, bool, error) {
+	conn, err := l.DB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := lockKey(job)
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked)
+	if err != nil || !locked {
+		// Whether the lock was taken is unknown after an error, so the connection isn't
+		// returned to the pool.
+		if err != nil {
+			discard(conn)
+		}
+		conn.Close()
+		return nil, false, err
+	}
+
+	release := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, key)
+		if err != nil {
+			// Closing the connection releases the lock, as long as it doesn't go back to the
+			// pool still holding it.
+			discard(conn)
+		}
+		conn.Close()
+		return err
+	}
+
+	query := `
+		INSERT INTO scheduled_jobs (name, last_slot, last_run_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (name) DO UPDATE
+		SET last_slot = EXCLUDED.last_slot, last_run_at = EXCLUDED.last_run_at
+		WHERE scheduled_jobs.last_slot < EXCLUDED.last_slot
+		`
+
+	result, err := conn.ExecContext(ctx, query, job, slot)
+	if err != nil {
+		release()
+		return nil, false, err
+	}
+
+	claimed, err := result.RowsAffected()
+	if err != nil || claimed == 0 {
+		release()
+		return nil, false, err
+	}
+
+	return release, true, nil
+}
+
+// discard marks conn as broken, so that closing it closes the connection to Postgres rather
+// than returning it to the pool.
+func discard(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+}
+
+// lockKey maps a job name to the 64-bit key of its advisory lock.
+func lockKey(job string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("scheduler:" + job))
+	return int64(h.Sum64())
+}
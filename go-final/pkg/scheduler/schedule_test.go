@@ -0,0 +1,110 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"1-x * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"@weekly",
+		"@every soon",
+		"@every 500ms",
+		"@every -1h",
+	}
+
+	for _, spec := range specs {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded; want an error", spec)
+		}
+	}
+}
+
+func TestParseString(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"@every 90m", "@every 1h30m0s"},
+		{" @every 1h ", "@every 1h0m0s"},
+		{"@hourly", "0 * * * *"},
+		{"@daily", "0 0 * * *"},
+		{"*/15 9-17 * * 1-5", "*/15 9-17 * * 1-5"},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q; want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, min, sec int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	}
+
+	// 2024-03-10 is a Sunday.
+	tests := []struct {
+		name string
+		spec string
+		t    time.Time
+		want time.Time
+	}{
+		{"every, aligned to the interval", "@every 15m", date(2024, 3, 10, 12, 34, 56), date(2024, 3, 10, 12, 45, 0)},
+		{"every, strictly after", "@every 1h", date(2024, 3, 10, 13, 0, 0), date(2024, 3, 10, 14, 0, 0)},
+		{"hourly", "@hourly", date(2024, 3, 10, 12, 0, 0), date(2024, 3, 10, 13, 0, 0)},
+		{"daily across a year", "@daily", date(2024, 12, 31, 23, 59, 30), date(2025, 1, 1, 0, 0, 0)},
+		{"across a month", "30 2 * * *", date(2024, 1, 31, 3, 0, 0), date(2024, 2, 1, 2, 30, 0)},
+		{"first of the month", "0 0 1 * *", date(2024, 1, 31, 12, 0, 0), date(2024, 2, 1, 0, 0, 0)},
+		{"month list across a year", "0 0 1 1,7 *", date(2024, 7, 1, 0, 0, 0), date(2025, 1, 1, 0, 0, 0)},
+		{"31st skips short months", "0 0 31 * *", date(2024, 3, 31, 1, 0, 0), date(2024, 5, 31, 0, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(2024, 3, 1, 0, 0, 0), date(2028, 2, 29, 0, 0, 0)},
+		{"hour list", "0 6,18 * * *", date(2024, 3, 10, 7, 0, 0), date(2024, 3, 10, 18, 0, 0)},
+		{"step with a start", "5/20 * * * *", date(2024, 3, 10, 12, 45, 0), date(2024, 3, 10, 13, 5, 0)},
+		{"range with a step", "10-30/10 * * * *", date(2024, 3, 10, 12, 30, 0), date(2024, 3, 10, 13, 10, 0)},
+		{"working hours over a weekend", "*/15 9-17 * * 1-5", date(2024, 3, 8, 17, 50, 0), date(2024, 3, 11, 9, 0, 0)},
+		{"Sunday as 7", "0 12 * * 7", date(2024, 3, 8, 0, 0, 0), date(2024, 3, 10, 12, 0, 0)},
+		{"Sunday as 0", "0 12 * * 0", date(2024, 3, 10, 12, 0, 0), date(2024, 3, 17, 12, 0, 0)},
+		// With both restricted, either the 13th or a Friday will do.
+		{"day of month or day of week", "0 0 13 * 5", date(2024, 3, 9, 0, 0, 0), date(2024, 3, 13, 0, 0, 0)},
+		{"day of week or day of month", "0 0 13 * 5", date(2024, 3, 13, 0, 0, 0), date(2024, 3, 15, 0, 0, 0)},
+		// With only the day of week restricted, the day of month doesn't widen it.
+		{"day of week alone", "0 0 * * 5", date(2024, 3, 13, 0, 0, 0), date(2024, 3, 15, 0, 0, 0)},
+		{"never", "0 0 30 2 *", date(2024, 1, 1, 0, 0, 0), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := s.Next(tt.t); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s; want %s", tt.t, got, tt.want)
+			}
+		})
+	}
+}
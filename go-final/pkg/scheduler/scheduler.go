@@ -0,0 +1,237 @@
+// Package scheduler runs periodic maintenance jobs inside the API process.
+//
+// Every replica of the API runs the same scheduler. Before a job runs, the replica claims the
+// run through a Locker, so that each scheduled run happens on exactly one replica; the others
+// skip it. Runs start at a random delay of up to the job's jitter after their scheduled time,
+// which spreads the load and makes it likely that a different replica wins each time.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// defaultTimeout limits a run whose job doesn't set a timeout.
+const defaultTimeout = 5 * time.Minute
+
+// Logger is the subset of the application's logger the scheduler writes to.
+type Logger interface {
+	PrintInfo(message string, properties map[string]string)
+	PrintError(err error, properties map[string]string)
+}
+
+// Locker elects the replica which performs a run.
+type Locker interface {
+	// Claim tries to take the run of the named job scheduled for slot. It returns false if
+	// another replica is running the job or has already run that slot. If it returns true,
+	// release must be called once the run is over, whether or not the run succeeded.
+	Claim(ctx context.Context, job string, slot time.Time) (release func() error, ok bool, err error)
+}
+
+// Job is a unit of work run on a schedule.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	// Jitter is the maximum random delay added to each scheduled time.
+	Jitter time.Duration
+	// Timeout cancels the context passed to Run. It defaults to five minutes.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// Status describes a job as seen by this replica.
+type Status struct {
+	Name         string     `json:"name"`
+	Schedule     string     `json:"schedule"`
+	Running      bool       `json:"running"`
+	NextRun      time.Time  `json:"next_run"`
+	LastRun      *time.Time `json:"last_run"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	// Runs and Failures count the runs performed by this replica, Skipped the ones left to
+	// another replica.
+	Runs     int `json:"runs"`
+	Failures int `json:"failures"`
+	Skipped  int `json:"skipped"`
+}
+
+type job struct {
+	Job
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Scheduler runs jobs until it is shut down.
+type Scheduler struct {
+	locker Locker
+	logger Logger
+	jobs   []*job
+
+	stop       chan struct{}
+	runCtx     context.Context
+	cancelRuns context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// New returns a scheduler for the given jobs. It does nothing until Start is called.
+func New(locker Locker, logger Logger, jobs ...Job) *Scheduler {
+	s := &Scheduler{
+		locker: locker,
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+	s.runCtx, s.cancelRuns = context.WithCancel(context.Background())
+
+	for _, j := range jobs {
+		if j.Timeout <= 0 {
+			j.Timeout = defaultTimeout
+		}
+		s.jobs = append(s.jobs, &job{
+			Job:    j,
+			status: Status{Name: j.Name, Schedule: j.Schedule.String()},
+		})
+	}
+
+	return s
+}
+
+// Start starts a goroutine for every job.
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+}
+
+// Shutdown stops scheduling new runs and waits for the running ones to finish. If ctx is done
+// first, the contexts of the running jobs are cancelled and ctx's error is returned.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	close(s.stop)
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancelRuns()
+		return nil
+	case <-ctx.Done():
+		s.cancelRuns()
+		return ctx.Err()
+	}
+}
+
+// Status returns the state of every job, in the order they were given to New.
+func (s *Scheduler) Status() []Status {
+	statuses := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		statuses = append(statuses, j.status)
+		j.mu.Unlock()
+	}
+	return statuses
+}
+
+func (s *Scheduler) loop(j *job) {
+	defer s.wg.Done()
+
+	for {
+		slot := j.Schedule.Next(time.Now())
+		if slot.IsZero() {
+			return
+		}
+
+		at := slot
+		if j.Jitter > 0 {
+			at = at.Add(time.Duration(rand.Int63n(int64(j.Jitter))))
+		}
+
+		j.mu.Lock()
+		j.status.NextRun = at
+		j.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.run(j, slot)
+	}
+}
+
+// run performs one scheduled run of a job, unless another replica claims it.
+func (s *Scheduler) run(j *job, slot time.Time) {
+	ctx, cancel := context.WithTimeout(s.runCtx, j.Timeout)
+	defer cancel()
+
+	props := map[string]string{"job": j.Name, "slot": slot.Format(time.RFC3339)}
+
+	release, ok, err := s.locker.Claim(ctx, j.Name, slot)
+	if err != nil {
+		s.logger.PrintError(fmt.Errorf("scheduler: claiming job: %w", err), props)
+		return
+	}
+
+	if !ok {
+		j.mu.Lock()
+		j.status.Skipped++
+		j.mu.Unlock()
+		return
+	}
+	defer func() {
+		if err := release(); err != nil {
+			s.logger.PrintError(fmt.Errorf("scheduler: releasing job: %w", err), props)
+		}
+	}()
+
+	start := time.Now()
+
+	j.mu.Lock()
+	j.status.Running = true
+	j.mu.Unlock()
+
+	err = safeRun(ctx, j.Run)
+	duration := time.Since(start)
+
+	j.mu.Lock()
+	j.status.Running = false
+	j.status.LastRun = &start
+	j.status.LastDuration = duration.String()
+	j.status.Runs++
+	j.status.LastError = ""
+	if err != nil {
+		j.status.Failures++
+		j.status.LastError = err.Error()
+	}
+	j.mu.Unlock()
+
+	props["duration"] = duration.String()
+
+	if err != nil {
+		s.logger.PrintError(err, props)
+		return
+	}
+
+	s.logger.PrintInfo("job completed", props)
+}
+
+// safeRun turns a panic in a job into an error, so that a broken job doesn't stop the others.
+func safeRun(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("scheduler: job panicked: %v", r)
+		}
+	}()
+
+	return run(ctx)
+}
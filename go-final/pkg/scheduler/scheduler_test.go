@@ -0,0 +1,242 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// fakeLocker grants or refuses every claim, and records the claims and releases.
+type fakeLocker struct {
+	grant      bool
+	releaseErr error
+
+	mu       sync.Mutex
+	claims   []time.Time
+	releases int
+}
+
+func (l *fakeLocker) Claim(ctx context.Context, job string, slot time.Time) (func() error, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.claims = append(l.claims, slot)
+	if !l.grant {
+		return nil, false, nil
+	}
+
+	return func() error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.releases++
+		return l.releaseErr
+	}, true, nil
+}
+
+// fakeLogger keeps the messages of the entries written to it.
+type fakeLogger struct {
+	mu     sync.Mutex
+	infos  []string
+	errors []string
+}
+
+func (l *fakeLogger) PrintInfo(message string, _ map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.infos = append(l.infos, message)
+}
+
+func (l *fakeLogger) PrintError(err error, _ map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errors = append(l.errors, err.Error())
+}
+
+func TestRun(t *testing.T) {
+	slot := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		grant      bool
+		releaseErr error
+		run        func(ctx context.Context) error
+		wantStatus Status
+		wantErrors []string
+	}{
+		{
+			name:       "claimed",
+			grant:      true,
+			run:        func(ctx context.Context) error { return nil },
+			wantStatus: Status{Runs: 1},
+		},
+		{
+			name:       "skipped",
+			grant:      false,
+			run:        func(ctx context.Context) error { panic("must not run") },
+			wantStatus: Status{Skipped: 1},
+		},
+		{
+			name:       "failed",
+			grant:      true,
+			run:        func(ctx context.Context) error { return errors.New("table is locked") },
+			wantStatus: Status{Runs: 1, Failures: 1, LastError: "table is locked"},
+			wantErrors: []string{"table is locked"},
+		},
+		{
+			name:       "panicked",
+			grant:      true,
+			run:        func(ctx context.Context) error { panic("nil map") },
+			wantStatus: Status{Runs: 1, Failures: 1, LastError: "scheduler: job panicked: nil map"},
+			wantErrors: []string{"scheduler: job panicked: nil map"},
+		},
+		{
+			name:       "release failed",
+			grant:      true,
+			releaseErr: errors.New("connection reset"),
+			run:        func(ctx context.Context) error { return nil },
+			wantStatus: Status{Runs: 1},
+			wantErrors: []string{"scheduler: releasing job: connection reset"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locker := &fakeLocker{grant: tt.grant, releaseErr: tt.releaseErr}
+			logger := &fakeLogger{}
+			s := New(locker, logger, Job{Name: "purge", Schedule: Every(time.Hour), Run: tt.run})
+
+			s.run(s.jobs[0], slot)
+
+			status := s.Status()[0]
+			if status.Runs != tt.wantStatus.Runs || status.Failures != tt.wantStatus.Failures ||
+				status.Skipped != tt.wantStatus.Skipped || status.LastError != tt.wantStatus.LastError {
+				t.Errorf("got status %+v; want %+v", status, tt.wantStatus)
+			}
+			if status.Running {
+				t.Error("got the job still running")
+			}
+
+			if len(locker.claims) != 1 || !locker.claims[0].Equal(slot) {
+				t.Errorf("got claims %v; want one for %s", locker.claims, slot)
+			}
+
+			wantReleases := 0
+			if tt.grant {
+				wantReleases = 1
+			}
+			if locker.releases != wantReleases {
+				t.Errorf("got %d releases; want %d", locker.releases, wantReleases)
+			}
+
+			if strings.Join(logger.errors, "\n") != strings.Join(tt.wantErrors, "\n") {
+				t.Errorf("got errors %q; want %q", logger.errors, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	locker := &fakeLocker{grant: true}
+	s := New(locker, &fakeLogger{}, Job{
+		Name:     "slow",
+		Schedule: Every(time.Hour),
+		Timeout:  10 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+
+	s.run(s.jobs[0], time.Now())
+
+	if status := s.Status()[0]; status.Failures != 1 || status.LastError != context.DeadlineExceeded.Error() {
+		t.Errorf("got status %+v; want a failure with %v", status, context.DeadlineExceeded)
+	}
+	if locker.releases != 1 {
+		t.Errorf("got %d releases; want 1", locker.releases)
+	}
+}
+
+func TestPostgresLocker(t *testing.T) {
+	slot := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	key := lockKey("purge")
+
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+		wantOK bool
+	}{
+		{
+			name: "claimed",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("pg_try_advisory_lock").WithArgs(key).
+					WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+				mock.ExpectExec("INSERT INTO scheduled_jobs").WithArgs("purge", slot).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantOK: true,
+		},
+		{
+			name: "running elsewhere",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("pg_try_advisory_lock").WithArgs(key).
+					WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+			},
+		},
+		{
+			name: "slot already run",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("pg_try_advisory_lock").WithArgs(key).
+					WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+				mock.ExpectExec("INSERT INTO scheduled_jobs").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("pg_advisory_unlock").WithArgs(key).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			tt.expect(mock)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			release, ok, err := NewPostgresLocker(db).Claim(ctx, "purge", slot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("got claimed %t; want %t", ok, tt.wantOK)
+			}
+
+			// The lock is released even when the run's context has been cancelled, and the slot,
+			// recorded outside of a transaction, stays recorded.
+			if ok {
+				cancel()
+
+				mock.ExpectExec("pg_advisory_unlock").WithArgs(key).WillReturnResult(sqlmock.NewResult(0, 0))
+				if err := release(); err != nil {
+					t.Errorf("got error %v releasing after the run was cancelled", err)
+				}
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Package totp implements time-based one-time passwords as described in RFC 6238, using the
+// HOTP algorithm from RFC 4226 with HMAC-SHA1, which is what authenticator apps expect.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Clock tells the current time. It exists so that tests can use a fake clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP generates and validates codes. The zero value is not usable; use New.
+type TOTP struct {
+	Clock  Clock
+	Period time.Duration
+	Digits int
+	// Skew is the number of periods before and after the current one that are still accepted,
+	// to allow for clock drift between the server and the user's device.
+	Skew int
+}
+
+// New returns a TOTP with the usual parameters: 30 second periods, 6 digits and one period of
+// skew. If clock is nil the system clock is used.
+func New(clock Clock) *TOTP {
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	return &TOTP{
+		Clock:  clock,
+		Period: 30 * time.Second,
+		Digits: 6,
+		Skew:   1,
+	}
+}
+
+// GenerateSecret returns a new random 160-bit secret, base-32 encoded.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth:// URI for the secret, which authenticator apps read from a QR code.
+func (t *TOTP) URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", t.Digits))
+	v.Set("period", fmt.Sprintf("%d", int(t.Period.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + account)
+
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Step returns the time step the given time falls into.
+func (t *TOTP) Step(at time.Time) int64 {
+	return at.Unix() / int64(t.Period.Seconds())
+}
+
+// Code returns the code for the secret at the current time.
+func (t *TOTP) Code(secret string) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return t.hotp(key, t.Step(t.Clock.Now())), nil
+}
+
+// Validate checks a code against the secret. Only time steps after lastStep are considered, so
+// that a code can't be used twice; callers store the returned step and pass it back next time.
+// It returns the matched step and true if the code is valid.
+func (t *TOTP) Validate(secret, code string, lastStep int64) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) != t.Digits {
+		return 0, false
+	}
+
+	current := t.Step(t.Clock.Now())
+
+	for i := -t.Skew; i <= t.Skew; i++ {
+		step := current + int64(i)
+		if step <= lastStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(t.hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// hotp computes the RFC 4226 HOTP value for a counter.
+func (t *TOTP) hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation: the low 4 bits of the last byte pick where to read 31 bits from.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < t.Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", t.Digits, value%mod)
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
@@ -0,0 +1,222 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock which stands still at the time it is set to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// rfcSecret is the SHA-1 secret of the test vectors in RFC 4226 and RFC 6238.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 4226, Appendix D.
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	key, err := decodeSecret(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := New(nil)
+	for counter, code := range want {
+		if got := tr.hotp(key, int64(counter)); got != code {
+			t.Errorf("counter %d: got %s; want %s", counter, got, code)
+		}
+	}
+}
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238, Appendix B, SHA-1.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		clock := &fakeClock{now: time.Unix(tt.unix, 0)}
+		tr := New(clock)
+		tr.Digits = 8
+
+		code, err := tr.Code(rfcSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("at %d: got %s; want %s", tt.unix, code, tt.code)
+		}
+
+		step, ok := tr.Validate(rfcSecret, tt.code, 0)
+		if !ok || step != tt.unix/30 {
+			t.Errorf("at %d: got step %d, valid %t; want step %d", tt.unix, step, ok, tt.unix/30)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1111111111, 0)}
+	tr := New(clock)
+	current := tr.Step(clock.now)
+
+	codeAt := func(offset int) string {
+		at := &fakeClock{now: clock.now.Add(time.Duration(offset) * tr.Period)}
+		code, err := New(at).Code(rfcSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+		valid  bool
+	}{
+		{"current period", 0, true},
+		{"previous period", -1, true},
+		{"next period", 1, true},
+		{"two periods ago", -2, false},
+		{"two periods ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := tr.Validate(rfcSecret, codeAt(tt.offset), 0)
+
+			if ok != tt.valid {
+				t.Fatalf("got valid %t; want %t", ok, tt.valid)
+			}
+			if ok && step != current+int64(tt.offset) {
+				t.Errorf("got step %d; want %d", step, current+int64(tt.offset))
+			}
+		})
+	}
+
+	t.Run("no skew", func(t *testing.T) {
+		strict := New(clock)
+		strict.Skew = 0
+
+		if _, ok := strict.Validate(rfcSecret, codeAt(-1), 0); ok {
+			t.Error("code of the previous period accepted without skew")
+		}
+		if _, ok := strict.Validate(rfcSecret, codeAt(0), 0); !ok {
+			t.Error("code of the current period refused")
+		}
+	})
+}
+
+func TestValidateReplay(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1234567890, 0)}
+	tr := New(clock)
+
+	code, err := tr.Code(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := tr.Validate(rfcSecret, code, 0)
+	if !ok {
+		t.Fatal("code refused the first time")
+	}
+
+	if _, ok := tr.Validate(rfcSecret, code, step); ok {
+		t.Error("code accepted a second time")
+	}
+
+	// Still within the skew window, but the step has been used.
+	clock.now = clock.now.Add(tr.Period)
+	if _, ok := tr.Validate(rfcSecret, code, step); ok {
+		t.Error("code accepted again in the next period")
+	}
+
+	// The next code is fine.
+	next, err := tr.Code(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tr.Validate(rfcSecret, next, step); !ok {
+		t.Error("code of the next period refused")
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1111111111, 0)}
+	tr := New(clock)
+
+	code, err := tr.Code(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		valid  bool
+	}{
+		{"surrounding spaces", rfcSecret, " " + code + " ", true},
+		{"lower case secret with spaces", strings.ToLower(rfcSecret[:8] + " " + rfcSecret[8:]), code, true},
+		{"too short", rfcSecret, code[:5], false},
+		{"too long", rfcSecret, code + "0", false},
+		{"invalid secret", "not base32!", code, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := tr.Validate(tt.secret, tt.code, 0); ok != tt.valid {
+				t.Errorf("got valid %t; want %t", ok, tt.valid)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := New(nil).URI("My Apishka", "harry@example.com", "JBSWY3DPEHPK3PXP")
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/My Apishka:harry@example.com" {
+		t.Errorf("got %s; want an otpauth://totp/ URI labelled issuer:account", uri)
+	}
+
+	q := u.Query()
+	for key, want := range map[string]string{"secret": "JBSWY3DPEHPK3PXP", "issuer": "My Apishka", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if q.Get(key) != want {
+			t.Errorf("got %s=%q; want %q", key, q.Get(key), want)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 20 {
+		t.Errorf("got a %d byte secret; want 20", len(key))
+	}
+}